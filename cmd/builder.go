@@ -15,10 +15,15 @@
 package cmd
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"net/url"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/codegangsta/cli"
 	"github.com/fsouza/go-dockerclient"
@@ -31,12 +36,15 @@ import (
 type DockerBuilder struct {
 	options       *core.PipelineOptions
 	dockerOptions *dockerlocal.DockerOptions
+	// checkouts of git services by URL, so we only fetch them once
+	checkouts map[string]string
 }
 
 func NewDockerBuilder(options *core.PipelineOptions, dockerOptions *dockerlocal.DockerOptions) *DockerBuilder {
 	return &DockerBuilder{
 		options:       options,
 		dockerOptions: dockerOptions,
+		checkouts:     map[string]string{},
 	}
 }
 
@@ -44,18 +52,40 @@ func (b *DockerBuilder) configURL(config *core.BoxConfig) (*url.URL, error) {
 	return url.Parse(config.URL)
 }
 
-func (b *DockerBuilder) getOptions(env *util.Environment, config *core.BoxConfig) (*core.PipelineOptions, error) {
+// servicePath is where the project of a file:// service lives, git
+// services are checked out into the project download path first
+func (b *DockerBuilder) servicePath(config *core.BoxConfig) (string, error) {
 	c, err := b.configURL(config)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	servicePath := filepath.Join(c.Host, c.Path)
-	if !filepath.IsAbs(servicePath) {
-		servicePath, err = filepath.Abs(
-			filepath.Join(b.options.ProjectPath, servicePath))
+	if config.IsGit() {
+		if dir, ok := b.checkouts[config.URL]; ok {
+			return dir, nil
+		}
+		repo, ref := gitService(c)
+		dir, err := checkoutGitService(b.options.ProjectDownloadPath(), repo, ref)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
+		b.checkouts[config.URL] = dir
+		return dir, nil
+	}
+	servicePath := filepath.Join(c.Host, c.Path)
+	if !filepath.IsAbs(servicePath) {
+		return filepath.Abs(filepath.Join(b.options.ProjectPath, servicePath))
+	}
+	return servicePath, nil
+}
+
+func (b *DockerBuilder) getOptions(env *util.Environment, config *core.BoxConfig) (*core.PipelineOptions, error) {
+	c, err := b.configURL(config)
+	if err != nil {
+		return nil, err
+	}
+	servicePath, err := b.servicePath(config)
+	if err != nil {
+		return nil, err
 	}
 
 	flagSet := func(name string, flags []cli.Flag) *flag.FlagSet {
@@ -84,14 +114,95 @@ func (b *DockerBuilder) getOptions(env *util.Environment, config *core.BoxConfig
 	newOptions.GlobalOptions = b.options.GlobalOptions
 	newOptions.ShouldCommit = true
 	newOptions.PublishPorts = b.options.PublishPorts
+	newOptions.RebuildServices = b.options.RebuildServices
 	newOptions.Pipeline = c.Fragment
 	return newOptions, nil
 }
 
-// Build the image and commit it so we can use it as a service
-func (b *DockerBuilder) Build(ctx context.Context, env *util.Environment, config *core.BoxConfig) (*dockerlocal.DockerBox, *docker.Image, error) {
-	newOptions, err := b.getOptions(env, config)
+// serviceCacheSkip are the directories of a service project that don't
+// affect the image we build from it
+var serviceCacheSkip = []string{".git", "_builds", "_cache", "_containers", "_projects", "_steps"}
+
+var unsafeRepoChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// serviceBoxConfig is the box an external service's pipeline builds on, nil
+// if the pipeline doesn't exist
+func (b *DockerBuilder) serviceBoxConfig(servicePath, pipelineName string) (*core.BoxConfig, error) {
+	werckerYaml, err := core.ReadWerckerYaml([]string{servicePath}, false)
+	if err != nil {
+		return nil, err
+	}
+	config, err := core.ConfigFromYaml(werckerYaml)
+	if err != nil {
+		return nil, err
+	}
+	pipelineConfig, ok := config.PipelinesMap[pipelineName]
+	if !ok {
+		return nil, nil
+	}
+	rawBoxConfig := pipelineConfig.Box
+	if rawBoxConfig == nil {
+		rawBoxConfig = config.Box
+	}
+	if rawBoxConfig == nil {
+		return nil, nil
+	}
+	return rawBoxConfig.BoxConfig, nil
+}
 
+// serviceCacheImage is the repository and tag an external service's image is
+// kept under, the tag is a hash of its source, the pipeline it runs and the
+// image and environment of the box that pipeline starts from
+func (b *DockerBuilder) serviceCacheImage(client dockerlocal.ContainerRuntime, env *util.Environment, config *core.BoxConfig, dockerOptions *dockerlocal.DockerOptions) (string, string, error) {
+	c, err := b.configURL(config)
+	if err != nil {
+		return "", "", err
+	}
+	servicePath, err := b.servicePath(config)
+	if err != nil {
+		return "", "", err
+	}
+	hash, err := util.HashDir(servicePath, serviceCacheSkip)
+	if err != nil {
+		return "", "", err
+	}
+	boxConfig, err := b.serviceBoxConfig(servicePath, c.Fragment)
+	if err != nil {
+		return "", "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s#%s\x00", hash, c.Fragment)
+	if boxConfig != nil {
+		// A box that isn't pulled yet goes by its name, one built from a
+		// Dockerfile is covered by the source
+		image := boxConfig.ID
+		if boxConfig.Dockerfile != "" {
+			image = ""
+		} else if box, err := dockerlocal.NewDockerBox(boxConfig, b.options, dockerOptions); err == nil {
+			image = box.Name
+			if i, err := client.InspectImage(box.Name); err == nil {
+				image = i.ID
+			}
+		}
+		fmt.Fprintf(h, "%s\x00", image)
+		boxEnv := []string{}
+		for k, v := range boxConfig.Env {
+			boxEnv = append(boxEnv, fmt.Sprintf("%s=%s", strings.ToUpper(k), env.Interpolate(v)))
+		}
+		sort.Strings(boxEnv)
+		for _, kv := range boxEnv {
+			fmt.Fprintf(h, "%s\x00", kv)
+		}
+	}
+	name := unsafeRepoChars.ReplaceAllString(strings.ToLower(filepath.Base(servicePath)), "-")
+	return "wercker-services/" + strings.Trim(name, "-._"), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Build the image and commit it so we can use it as a service, an image
+// built from the same source before is used as is unless RebuildServices
+func (b *DockerBuilder) Build(ctx context.Context, env *util.Environment, config *core.BoxConfig) (*dockerlocal.DockerBox, *docker.Image, error) {
+	e, err := core.EmitterFromContext(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -99,23 +210,54 @@ func (b *DockerBuilder) Build(ctx context.Context, env *util.Environment, config
 	newDockerOptions := *b.dockerOptions
 	newDockerOptions.DockerLocal = true
 
-	shared, err := cmdBuild(ctx, newOptions, &newDockerOptions)
+	client, err := dockerlocal.NewContainerRuntime(&newDockerOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repo, tag, err := b.serviceCacheImage(client, env, config, &newDockerOptions)
 	if err != nil {
 		return nil, nil, err
 	}
+	cached := fmt.Sprintf("%s:%s", repo, tag)
+
+	_, err = client.InspectImage(cached)
+	if err == nil && !b.options.RebuildServices {
+		e.Emit(core.Logs, &core.LogsArgs{
+			Logs: fmt.Sprintf("Using cached image for service %s\n", config.ID),
+		})
+	} else {
+		newOptions, err := b.getOptions(env, config)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		shared, err := cmdBuild(ctx, newOptions, &newDockerOptions)
+		if err != nil {
+			return nil, nil, err
+		}
+		built := fmt.Sprintf("%s:%s", shared.pipeline.DockerRepo(), shared.pipeline.DockerTag())
+		err = client.TagImage(built, docker.TagImageOptions{
+			Repo:  repo,
+			Tag:   tag,
+			Force: true,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// TODO(termie): this causes the ID to get overwritten but
 	//               we want the shortname that the user specified as an ID
 	//               so we probably wnat to make a copy or something here
 	bc := config
-	bc.ID = fmt.Sprintf("%s:%s", shared.pipeline.DockerRepo(),
-		shared.pipeline.DockerTag())
+	bc.ID = cached
 
 	box, err := dockerlocal.NewDockerBox(bc, b.options, &newDockerOptions)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	client, err := dockerlocal.NewDockerClient(&newDockerOptions)
 	image, err := client.InspectImage(box.Name)
 	if err != nil {
 		return nil, nil, err
@@ -0,0 +1,38 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/wercker/wercker/docker"
+	"github.com/wercker/wercker/util"
+)
+
+// cmdExec runs command, or a shell, in the container of a running pipeline
+// without getting in the way of the step it's running
+func cmdExec(dockerOptions *dockerlocal.DockerOptions, pipelineID string, command []string) error {
+	client, err := dockerlocal.NewContainerRuntime(dockerOptions)
+	if err != nil {
+		return err
+	}
+	container, err := dockerlocal.FindPipelineContainer(client, pipelineID)
+	if err != nil {
+		return err
+	}
+	// The pipeline only saved its environment without the hidden part
+	hidden := util.NewEnvironment(os.Environ()...).GetHiddenPassthru()
+	return dockerlocal.ExecInPipeline(client, container, command, hidden, os.Stdout, os.Stderr)
+}
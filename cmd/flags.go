@@ -40,6 +40,7 @@ var (
 		cli.StringFlag{Name: "docker-cert-path", Value: "", Usage: "Docker api cert path.", EnvVar: "DOCKER_CERT_PATH"},
 		cli.StringSliceFlag{Name: "docker-dns", Value: &cli.StringSlice{0: "8.8.8.8", 1: "8.8.4.4"}, Usage: "Docker DNS server.", EnvVar: "DOCKER_DNS", Hidden: true},
 		cli.BoolFlag{Name: "docker-local", Usage: "Don't interact with remote repositories"},
+		cli.StringFlag{Name: "runtime", Value: "docker", Usage: "Container runtime to use (docker or podman).", EnvVar: "WERCKER_RUNTIME"},
 	}
 
 	// These flags control where we store local files
@@ -94,6 +95,10 @@ var (
 		cli.StringFlag{Name: "commit", Value: "", Usage: "Commit the build result locally."},
 		cli.StringFlag{Name: "tag", Value: "", Usage: "Tag for this build.", EnvVar: "WERCKER_GIT_BRANCH"},
 		cli.StringFlag{Name: "message", Value: "", Usage: "Message for this build."},
+		cli.BoolFlag{Name: "squash", Usage: "Squash the committed container to a single layer on top of its box. The size report after a commit only lists the largest added files when squashing."},
+		cli.BoolFlag{Name: "checkpoint", Usage: "Commit the container after every step so wercker rerun can start from there."},
+		cli.BoolFlag{Name: "cache-steps", Usage: "Commit every step under a hash of its inputs and skip the steps that match on the next run."},
+		cli.IntFlag{Name: "cache-steps-keep", Value: 3, Usage: "How many runs of a pipeline to keep cached steps for."},
 	}
 
 	// These flags affect our artifact interactions
@@ -113,7 +118,8 @@ var (
 	DevFlags = []cli.Flag{
 		cli.StringFlag{Name: "environment", Value: "ENVIRONMENT", Usage: "Specify additional environment variables in a file."},
 		cli.BoolFlag{Name: "verbose", Usage: "Print more information."},
-		cli.BoolFlag{Name: "no-colors", Usage: "Wercker output will not use colors (does not apply to step output)."},
+		cli.BoolFlag{Name: "quiet", Usage: "Only print what steps write to stderr."},
+		cli.BoolFlag{Name: "no-colors", Usage: "Wercker output will not use colors (step output only gets colored where it went to stderr)."},
 		cli.BoolFlag{Name: "debug", Usage: "Print additional debug information."},
 		cli.BoolFlag{Name: "journal", Usage: "Send logs to systemd-journald. Suppresses stdout logging."},
 	}
@@ -122,7 +128,12 @@ var (
 	InternalDevFlags = []cli.Flag{
 		cli.BoolTFlag{Name: "direct-mount", Usage: "Mount our binds read-write to the pipeline path."},
 		cli.StringSliceFlag{Name: "publish", Value: &cli.StringSlice{}, Usage: "Publish a port from the main container, same format as docker --publish."},
+		cli.BoolFlag{Name: "allow-privileged", Usage: "Allow boxes to run privileged, add capabilities, map devices and mount host paths."},
+		cli.BoolFlag{Name: "rebuild-services", Usage: "Rebuild file:// and git services even if an image for their current source exists."},
+		cli.BoolFlag{Name: "keep-services", Usage: "Keep services running after the pipeline and reuse them in the next run if they haven't changed."},
 		cli.BoolFlag{Name: "attach-on-error", Usage: "Attach shell to container if a step fails.", Hidden: true},
+		cli.StringSliceFlag{Name: "break-before", Value: &cli.StringSlice{}, Usage: "Attach shell to container before the step with this display name or id runs, the pipeline continues when it exits."},
+		cli.StringSliceFlag{Name: "break-after", Value: &cli.StringSlice{}, Usage: "Attach shell to container after the step with this display name or id runs, the pipeline continues when it exits."},
 		cli.BoolTFlag{Name: "enable-dev-steps", Hidden: true, Usage: `
 		Enable internal dev steps.
 		This enables:
@@ -134,7 +145,12 @@ var (
 	InternalBuildFlags = []cli.Flag{
 		cli.BoolFlag{Name: "direct-mount", Usage: "Mount our binds read-write to the pipeline path."},
 		cli.StringSliceFlag{Name: "publish", Value: &cli.StringSlice{}, Usage: "Publish a port from the main container, same format as docker --publish."},
+		cli.BoolFlag{Name: "allow-privileged", Usage: "Allow boxes to run privileged, add capabilities, map devices and mount host paths."},
+		cli.BoolFlag{Name: "rebuild-services", Usage: "Rebuild file:// and git services even if an image for their current source exists."},
+		cli.BoolFlag{Name: "keep-services", Usage: "Keep services running after the pipeline and reuse them in the next run if they haven't changed."},
 		cli.BoolFlag{Name: "attach-on-error", Usage: "Attach shell to container if a step fails.", Hidden: true},
+		cli.StringSliceFlag{Name: "break-before", Value: &cli.StringSlice{}, Usage: "Attach shell to container before the step with this display name or id runs, the pipeline continues when it exits."},
+		cli.StringSliceFlag{Name: "break-after", Value: &cli.StringSlice{}, Usage: "Attach shell to container after the step with this display name or id runs, the pipeline continues when it exits."},
 		cli.BoolFlag{Name: "enable-dev-steps", Hidden: true, Usage: `
 		Enable internal dev steps.
 		This enables:
@@ -145,7 +161,11 @@ var (
 	// Flags for advanced deploy settings
 	InternalDeployFlags = []cli.Flag{
 		cli.StringSliceFlag{Name: "publish", Value: &cli.StringSlice{}, Usage: "Publish a port from the main container, same format as docker --publish."},
+		cli.BoolFlag{Name: "allow-privileged", Usage: "Allow boxes to run privileged, add capabilities, map devices and mount host paths."},
+		cli.BoolFlag{Name: "rebuild-services", Usage: "Rebuild file:// and git services even if an image for their current source exists."},
 		cli.BoolFlag{Name: "attach-on-error", Usage: "Attach shell to container if a step fails.", Hidden: true},
+		cli.StringSliceFlag{Name: "break-before", Value: &cli.StringSlice{}, Usage: "Attach shell to container before the step with this display name or id runs, the pipeline continues when it exits."},
+		cli.StringSliceFlag{Name: "break-after", Value: &cli.StringSlice{}, Usage: "Attach shell to container after the step with this display name or id runs, the pipeline continues when it exits."},
 		cli.BoolFlag{Name: "enable-dev-steps", Hidden: true, Usage: `
 		Enable internal dev steps.
 		This enables:
@@ -173,6 +193,10 @@ var (
 		cli.BoolFlag{Name: "report", Usage: "Report logs back to wercker (requires build-id, wercker-host, wercker-token).", Hidden: true},
 		cli.StringFlag{Name: "wercker-host", Usage: "Wercker host to use for wercker reporter.", Hidden: true},
 		cli.StringFlag{Name: "wercker-token", Usage: "Wercker token to use for wercker reporter.", Hidden: true},
+		cli.StringFlag{Name: "events-json", Value: "", Usage: "Write build events as JSON lines to a file or fd:N for an open file descriptor."},
+		cli.StringSliceFlag{Name: "webhook", Value: &cli.StringSlice{}, Usage: "POST build notifications to this URL, can be given more than once."},
+		cli.StringFlag{Name: "webhook-secret", Value: "", EnvVar: "WERCKER_WEBHOOK_SECRET", Usage: "Sign webhook payloads with an HMAC-SHA256 of this secret."},
+		cli.StringSliceFlag{Name: "webhook-events", Value: &cli.StringSlice{}, Usage: "Only send these events to --webhook URLs: buildStarted, buildStepFinished, fullPipelineFinished."},
 	}
 
 	// These options might be overwritten by the wercker.yml
@@ -193,6 +217,18 @@ var (
 		},
 	}
 
+	RerunFlagSet = [][]cli.Flag{
+		[]cli.Flag{
+			cli.StringFlag{Name: "from", Value: "", Usage: "Step to rerun the pipeline from, the steps before it are restored from their checkpoints."},
+		},
+	}
+
+	ExecFlagSet = [][]cli.Flag{
+		[]cli.Flag{
+			cli.StringFlag{Name: "pipeline-id", Value: "", Usage: "Pipeline to exec into, defaults to the most recent one."},
+		},
+	}
+
 	GlobalFlagSet = [][]cli.Flag{
 		DevFlags,
 		EndpointFlags,
@@ -0,0 +1,115 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/wercker/wercker/util"
+)
+
+// gitService splits a git+ssh:// or git+https:// service URL into what we
+// clone and the ref to check out, the fragment still names the pipeline
+func gitService(u *url.URL) (string, string) {
+	clone := *u
+	clone.Scheme = strings.TrimPrefix(u.Scheme, "git+")
+	clone.Fragment = ""
+	ref := ""
+	if i := strings.LastIndex(clone.Path, "@"); i >= 0 {
+		ref = clone.Path[i+1:]
+		clone.Path = clone.Path[:i]
+		clone.RawPath = ""
+	}
+	return clone.String(), ref
+}
+
+// checkoutGitService clones repo under downloadPath, or updates an earlier
+// clone, and checks out ref (the remote's HEAD if empty). Every repo and ref
+// gets its own checkout so services can use different versions.
+func checkoutGitService(downloadPath, repo, ref string) (string, error) {
+	// Anything starting with a dash would be taken for an option
+	if strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("Invalid ref %s for service %s", ref, repo)
+	}
+	git, err := exec.LookPath("git")
+	if err != nil {
+		return "", fmt.Errorf("Service %s needs git: %s", repo, err)
+	}
+	output := func(dir string, args ...string) (string, error) {
+		var out bytes.Buffer
+		cmd := exec.Command(git, args...)
+		cmd.Dir = dir
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("git %s for service %s failed: %s\n%s", args[0], repo, err, out.String())
+		}
+		return strings.TrimSpace(out.String()), nil
+	}
+	run := func(dir string, args ...string) error {
+		_, err := output(dir, args...)
+		return err
+	}
+
+	sum := sha256.Sum256([]byte(repo + "@" + ref))
+	name := strings.TrimSuffix(path.Base(repo), ".git")
+	dir := filepath.Join(downloadPath, "services", fmt.Sprintf("%s-%s", name, hex.EncodeToString(sum[:4])))
+
+	exists, err := util.Exists(filepath.Join(dir, ".git"))
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return "", err
+		}
+		if err := run(filepath.Dir(dir), "clone", "--quiet", "--", repo, dir); err != nil {
+			return "", err
+		}
+	} else if err := run(dir, "fetch", "--quiet", "--tags", "origin"); err != nil {
+		return "", err
+	}
+
+	target := "origin/HEAD"
+	if ref != "" {
+		target = ref
+		// Branches only move as remote tracking branches
+		if run(dir, "rev-parse", "--verify", "--quiet", "origin/"+ref+"^{commit}") == nil {
+			target = "origin/" + ref
+		}
+	}
+	// Check out the commit itself so the ref can't be read as anything else
+	commit, err := output(dir, "rev-parse", "--verify", "--quiet", target+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("Unknown ref %s for service %s", ref, repo)
+	}
+	if err := run(dir, "checkout", "--quiet", "--force", "--detach", commit); err != nil {
+		return "", err
+	}
+	// Leftovers would end up in the image and its cache key
+	if err := run(dir, "clean", "--quiet", "--force", "-d", "-x"); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
@@ -0,0 +1,104 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/util"
+)
+
+type GitServiceSuite struct {
+	*util.TestSuite
+}
+
+func TestGitServiceSuite(t *testing.T) {
+	suiteTester := &GitServiceSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *GitServiceSuite) TestGitService() {
+	tests := []struct {
+		url, repo, ref string
+	}{
+		{"git+https://github.com/wercker/api.git#dev", "https://github.com/wercker/api.git", ""},
+		{"git+https://github.com/wercker/api.git@v1.2#dev", "https://github.com/wercker/api.git", "v1.2"},
+		{"git+ssh://git@github.com/wercker/api.git@feature/x", "ssh://git@github.com/wercker/api.git", "feature/x"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		s.Require().NoError(err)
+		repo, ref := gitService(u)
+		s.Equal(test.repo, repo, test.url)
+		s.Equal(test.ref, ref, test.url)
+	}
+}
+
+func (s *GitServiceSuite) TestCheckoutGitService() {
+	if _, err := exec.LookPath("git"); err != nil {
+		s.T().Skip("git is not installed")
+	}
+	repo := filepath.Join(s.WorkingDir(), "api")
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=wercker", "-c", "user.email=wercker@localhost"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		s.Require().NoError(err, string(out))
+	}
+	commit := func(content string) {
+		err := ioutil.WriteFile(filepath.Join(repo, "wercker.yml"), []byte(content), 0644)
+		s.Require().NoError(err)
+		git("add", "wercker.yml")
+		git("commit", "--quiet", "-m", content)
+	}
+
+	s.Require().NoError(exec.Command("git", "init", "--quiet", repo).Run())
+	commit("box: alpine\n")
+	git("tag", "v1")
+	commit("box: busybox\n")
+
+	downloads := filepath.Join(s.WorkingDir(), "_projects")
+	dir, err := checkoutGitService(downloads, repo, "")
+	s.Require().NoError(err)
+	content, err := ioutil.ReadFile(filepath.Join(dir, "wercker.yml"))
+	s.NoError(err)
+	s.Equal("box: busybox\n", string(content))
+
+	tagged, err := checkoutGitService(downloads, repo, "v1")
+	s.Require().NoError(err)
+	s.NotEqual(dir, tagged)
+	content, err = ioutil.ReadFile(filepath.Join(tagged, "wercker.yml"))
+	s.NoError(err)
+	s.Equal("box: alpine\n", string(content))
+
+	// A second checkout fetches what changed since
+	commit("box: ubuntu\n")
+	dir, err = checkoutGitService(downloads, repo, "")
+	s.Require().NoError(err)
+	content, err = ioutil.ReadFile(filepath.Join(dir, "wercker.yml"))
+	s.NoError(err)
+	s.Equal("box: ubuntu\n", string(content))
+
+	// Refs can't pass options to git or name anything but a commit
+	_, err = checkoutGitService(downloads, repo, "--orphan=evil")
+	s.Error(err)
+	_, err = checkoutGitService(downloads, repo, "missing")
+	s.Error(err)
+}
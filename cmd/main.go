@@ -74,6 +74,35 @@ var (
 		Flags: FlagsFor(PipelineFlagSet, WerckerInternalFlagSet),
 	}
 
+	rerunCommand = cli.Command{
+		Name:        "rerun",
+		Usage:       "rerun --from <step>",
+		Description: "rerun a pipeline from a step using the checkpoints of an earlier run with --checkpoint",
+		Action: func(c *cli.Context) {
+			envfile := c.GlobalString("environment")
+			_ = godotenv.Load(envfile)
+
+			env := util.NewEnvironment(os.Environ()...)
+
+			settings := util.NewCLISettings(c)
+			opts, err := core.NewBuildOptions(settings, env)
+			if err != nil {
+				cliLogger.Errorln("Invalid options\n", err)
+				os.Exit(1)
+			}
+			dockerOptions, err := dockerlocal.NewDockerOptions(settings, env)
+			if err != nil {
+				cliLogger.Errorln("Invalid options\n", err)
+				os.Exit(1)
+			}
+			_, err = cmdRerun(context.Background(), opts, dockerOptions)
+			if err != nil {
+				cliLogger.Fatal(err)
+			}
+		},
+		Flags: FlagsFor(PipelineFlagSet, WerckerInternalFlagSet, RerunFlagSet),
+	}
+
 	devCommand = cli.Command{
 		Name:  "dev",
 		Usage: "develop and run a local project",
@@ -276,6 +305,63 @@ var (
 		},
 	}
 
+	execCommand = cli.Command{
+		Name:        "exec",
+		Usage:       "exec [--pipeline-id X] [-- cmd]",
+		Description: "run a command, or a shell, in the container of a running pipeline",
+		Flags:       FlagsFor(DockerFlagSet, ExecFlagSet),
+		Action: func(c *cli.Context) {
+			dockerOptions := servicesDockerOptions(c)
+			err := cmdExec(dockerOptions, c.String("pipeline-id"), c.Args())
+			if err != nil {
+				cliLogger.Fatal(err)
+			}
+		},
+	}
+
+	servicesCommand = cli.Command{
+		Name:  "services",
+		Usage: "manage services kept running by --keep-services",
+		Subcommands: []cli.Command{
+			{
+				Name:  "ls",
+				Usage: "list kept services",
+				Flags: FlagsFor(DockerFlagSet),
+				Action: func(c *cli.Context) {
+					dockerOptions := servicesDockerOptions(c)
+					err := cmdServicesList(dockerOptions, os.Stdout)
+					if err != nil {
+						cliLogger.Fatal(err)
+					}
+				},
+			},
+			{
+				Name:  "stop",
+				Usage: "stop [service or project...], stops all kept services if none are given",
+				Flags: FlagsFor(DockerFlagSet),
+				Action: func(c *cli.Context) {
+					dockerOptions := servicesDockerOptions(c)
+					err := cmdServicesStop(dockerOptions, c.Args())
+					if err != nil {
+						cliLogger.Fatal(err)
+					}
+				},
+			},
+			{
+				Name:  "rm",
+				Usage: "rm [service or project...], removes all kept services if none are given",
+				Flags: FlagsFor(DockerFlagSet),
+				Action: func(c *cli.Context) {
+					dockerOptions := servicesDockerOptions(c)
+					err := cmdServicesRemove(dockerOptions, c.Args())
+					if err != nil {
+						cliLogger.Fatal(err)
+					}
+				},
+			},
+		},
+	}
+
 	versionCommand = cli.Command{
 		Name:      "version",
 		ShortName: "v",
@@ -344,14 +430,17 @@ func GetApp() *cli.App {
 	app.Flags = FlagsFor(GlobalFlagSet)
 	app.Commands = []cli.Command{
 		buildCommand,
+		rerunCommand,
 		devCommand,
 		checkConfigCommand,
 		deployCommand,
 		detectCommand,
+		execCommand,
 		// inspectCommand,
 		loginCommand,
 		logoutCommand,
 		pullCommand,
+		servicesCommand,
 		versionCommand,
 		documentCommand(app),
 	}
@@ -359,6 +448,11 @@ func GetApp() *cli.App {
 		if ctx.GlobalBool("debug") {
 			util.RootLogger().Formatter = &util.VerboseFormatter{}
 			util.RootLogger().SetLevel("debug")
+		} else if ctx.GlobalBool("quiet") {
+			// Step output that went to stderr is logged on its own, this
+			// leaves out our progress messages
+			util.RootLogger().Formatter = &util.TerseFormatter{}
+			util.RootLogger().SetLevel("warn")
 		} else {
 			util.RootLogger().Formatter = &util.TerseFormatter{}
 			util.RootLogger().SetLevel("info")
@@ -375,6 +469,18 @@ func GetApp() *cli.App {
 	return app
 }
 
+// servicesDockerOptions is all the services subcommands need
+func servicesDockerOptions(c *cli.Context) *dockerlocal.DockerOptions {
+	settings := util.NewCLISettings(c)
+	env := util.NewEnvironment(os.Environ()...)
+	dockerOptions, err := dockerlocal.NewDockerOptions(settings, env)
+	if err != nil {
+		cliLogger.Errorln("Invalid options\n", err)
+		os.Exit(1)
+	}
+	return dockerOptions
+}
+
 // SoftExit is a helper for determining when to show stack traces
 type SoftExit struct {
 	options *core.GlobalOptions
@@ -413,6 +519,13 @@ func cmdBuild(ctx context.Context, options *core.PipelineOptions, dockerOptions
 	return executePipeline(ctx, options, dockerOptions, pipelineGetter)
 }
 
+func cmdRerun(ctx context.Context, options *core.PipelineOptions, dockerOptions *dockerlocal.DockerOptions) (*RunnerShared, error) {
+	if options.ResumeFrom == "" {
+		return nil, fmt.Errorf("rerun needs a step to start from, use --from")
+	}
+	return cmdBuild(ctx, options, dockerOptions)
+}
+
 func cmdDeploy(ctx context.Context, options *core.PipelineOptions, dockerOptions *dockerlocal.DockerOptions) (*RunnerShared, error) {
 	if options.Pipeline == "" {
 		options.Pipeline = "deploy"
@@ -519,7 +632,7 @@ func cmdInspect(options *core.InspectOptions, dockerOptions *dockerlocal.DockerO
 	repoName := fmt.Sprintf("%s/%s", options.ApplicationOwnerName, options.ApplicationName)
 	tag := options.Tag
 
-	client, err := dockerlocal.NewDockerClient(dockerOptions)
+	client, err := dockerlocal.NewContainerRuntime(dockerOptions)
 	if err != nil {
 		return err
 	}
@@ -672,7 +785,7 @@ func cmdPull(c *cli.Context, options *core.PullOptions, dockerOptions *dockerloc
 			return soft.Exit(err)
 		}
 
-		dockerClient, err := dockerlocal.NewDockerClient(dockerOptions)
+		dockerClient, err := dockerlocal.NewContainerRuntime(dockerOptions)
 		if err != nil {
 			logger.WithField("Error", err).Error("Unable to create Docker client")
 			return soft.Exit(err)
@@ -863,6 +976,8 @@ func executePipeline(cmdCtx context.Context, options *core.PipelineOptions, dock
 	if err != nil {
 		return nil, err
 	}
+	// Deferred first so it runs after the final events
+	defer r.Close()
 
 	// Main timer
 	mainTimer := util.NewTimer()
@@ -912,6 +1027,15 @@ func executePipeline(cmdCtx context.Context, options *core.PipelineOptions, dock
 		if options.ShouldRemove {
 			defer shared.box.Clean()
 		}
+		// Service logs are most useful when things went wrong, so store
+		// them either way, once the services stopped
+		if options.ShouldArtifacts {
+			defer func() {
+				if err := r.StoreServiceLogs(shared); err != nil {
+					logger.WithField("Error", err).Error("Unable to store service logs")
+				}
+			}()
+		}
 		defer shared.box.Stop()
 	}
 	if err != nil {
@@ -965,7 +1089,14 @@ func executePipeline(cmdCtx context.Context, options *core.PipelineOptions, dock
 	// stepCounter starts at 3, step 1 is "get code", step 2 is "setup
 	// environment".
 	stepCounter := &util.Counter{Current: 3}
-	for _, step := range pipeline.Steps() {
+	for i, step := range pipeline.Steps() {
+		// Rerunning from a checkpoint or cached, these already ran
+		if i < shared.resumeFrom {
+			logger.Printf(f.Info("Skipping step", step.DisplayName()))
+			r.SkipStep(shared, step, stepCounter.Increment())
+			continue
+		}
+
 		logger.Printf(f.Info("Running step", step.DisplayName()))
 		timer.Reset()
 		sr, err := r.RunStep(shared, step, stepCounter.Increment())
@@ -980,10 +1111,25 @@ func executePipeline(cmdCtx context.Context, options *core.PipelineOptions, dock
 		if options.Verbose {
 			logger.Printf(f.Success("Step passed", step.DisplayName(), timer.String()))
 		}
+
+		tags := []string{}
+		if options.ShouldCheckpoint {
+			tags = append(tags, checkpointTag(options.Pipeline, i))
+		}
+		if options.ShouldCacheSteps && shared.stepKeys != nil {
+			tags = append(tags, shared.stepKeys[i])
+		}
+		if err := r.Checkpoint(shared, i, tags...); err != nil {
+			logger.WithField("Error", err).Warn("Unable to checkpoint")
+		}
+	}
+
+	if err := r.PruneCache(shared); err != nil {
+		logger.WithField("Error", err).Warn("Unable to prune cached steps")
 	}
 
 	if options.ShouldCommit {
-		_, err = box.Commit(repoName, tag, message)
+		_, err = box.Commit(shared.sessionCtx, repoName, tag, message)
 		if err != nil {
 			logger.Errorln("Failed to commit:", err.Error())
 		}
@@ -1101,7 +1247,7 @@ func executePipeline(cmdCtx context.Context, options *core.PipelineOptions, dock
 		logger.Panicln(err)
 	}
 
-	newSessCtx, newSess, err := r.GetSession(cmdCtx, container.ID)
+	newSessCtx, newSess, err := r.GetSession(cmdCtx, box, container.ID, pipelineTransport(shared.config, options.Pipeline))
 	if err != nil {
 		logger.Panicln(err)
 	}
@@ -0,0 +1,188 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/docker"
+	"github.com/wercker/wercker/internal/fakeruntime"
+	"github.com/wercker/wercker/internal/fakeruntime/sandbox"
+	"github.com/wercker/wercker/util"
+	"golang.org/x/net/context"
+)
+
+// PipelineSuite runs whole pipelines against the fake runtime
+type PipelineSuite struct {
+	*util.TestSuite
+}
+
+func TestPipelineSuite(t *testing.T) {
+	suiteTester := &PipelineSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+type pipelineCommand func(context.Context, *core.PipelineOptions, *dockerlocal.DockerOptions) (*RunnerShared, error)
+
+func (s *PipelineSuite) run(command pipelineCommand, project, pipeline string) error {
+	_, err := s.runWithOptions(command, project, pipeline)
+	return err
+}
+
+func (s *PipelineSuite) runWithOptions(command pipelineCommand, project, pipeline string) (*core.PipelineOptions, error) {
+	fake, dockerOptions, err := fakeruntime.New(filepath.Join(s.WorkingDir(), "sandbox"))
+	s.Require().NoError(err)
+	defer fake.Close()
+	return s.runInFake(fake, dockerOptions, command, project, pipeline, nil)
+}
+
+// runInFake runs a pipeline against an existing fake runtime, extra settings
+// are added to the defaults
+func (s *PipelineSuite) runInFake(fake *sandbox.Runtime, dockerOptions *dockerlocal.DockerOptions, command pipelineCommand, project, pipeline string, extra map[string]interface{}) (*core.PipelineOptions, error) {
+	workingDir := s.WorkingDir()
+
+	// wercker-init would otherwise be downloaded from GitHub
+	err := os.MkdirAll(filepath.Join(workingDir, "_steps", "wercker-wercker-init"), 0755)
+	s.Require().NoError(err)
+
+	target, err := filepath.Abs(filepath.Join("..", "tests", "projects", project))
+	s.Require().NoError(err)
+
+	values := map[string]interface{}{
+		"target":              target,
+		"working-dir":         workingDir,
+		"pipeline":            pipeline,
+		"guest-root":          filepath.Join(fake.Root(), "pipeline"),
+		"mnt-root":            filepath.Join(fake.Root(), "mnt"),
+		"report-root":         filepath.Join(fake.Root(), "report"),
+		"command-timeout":     1.0,
+		"no-response-timeout": 1.0,
+	}
+	for k, v := range extra {
+		values[k] = v
+	}
+	options, err := core.NewBuildOptions(util.NewCheapSettings(values), util.NewEnvironment())
+	s.Require().NoError(err)
+
+	_, err = command(context.Background(), options, dockerOptions)
+	return options, err
+}
+
+func (s *PipelineSuite) TestBuildPasses() {
+	s.NoError(s.run(cmdBuild, "after-steps-fail", "build_true"))
+}
+
+func (s *PipelineSuite) TestBuildFails() {
+	s.Error(s.run(cmdBuild, "after-steps-fail", "build_fail"))
+	s.Error(s.run(cmdBuild, "fail", "build"))
+}
+
+func (s *PipelineSuite) TestExecTransport() {
+	s.NoError(s.run(cmdBuild, "exec-transport", "build"))
+	s.Error(s.run(cmdBuild, "exec-transport", "build_fail"))
+}
+
+func (s *PipelineSuite) TestHostBox() {
+	s.NoError(s.run(cmdBuild, "host-box", "build"))
+	s.Error(s.run(cmdBuild, "host-box", "build_fail"))
+
+	_, err := os.Stat(filepath.Join("..", "tests", "projects", "host-box", "scratch-only"))
+	s.True(os.IsNotExist(err), "steps should not touch the project")
+}
+
+func (s *PipelineSuite) TestRerun() {
+	fake, dockerOptions, err := fakeruntime.New(filepath.Join(s.WorkingDir(), "sandbox"))
+	s.Require().NoError(err)
+	defer fake.Close()
+
+	_, err = s.runInFake(fake, dockerOptions, cmdRerun, "checkpoint", "build", map[string]interface{}{"from": "three"})
+	s.Error(err, "there are no checkpoints yet")
+
+	_, err = s.runInFake(fake, dockerOptions, cmdBuild, "checkpoint", "build", map[string]interface{}{"checkpoint": true})
+	s.Require().NoError(err)
+
+	options, err := s.runInFake(fake, dockerOptions, cmdRerun, "checkpoint", "build", map[string]interface{}{"from": "three"})
+	s.Require().NoError(err)
+	ran, err := ioutil.ReadFile(filepath.Join(options.SourcePath(), "one-ran"))
+	s.Require().NoError(err)
+	s.Equal("ran\n", string(ran), "step one should not run again")
+
+	_, err = s.runInFake(fake, dockerOptions, cmdRerun, "checkpoint", "build", map[string]interface{}{"from": "four"})
+	s.Error(err)
+}
+
+func (s *PipelineSuite) TestCacheSteps() {
+	fake, dockerOptions, err := fakeruntime.New(filepath.Join(s.WorkingDir(), "sandbox"))
+	s.Require().NoError(err)
+	defer fake.Close()
+
+	_, err = s.runInFake(fake, dockerOptions, cmdBuild, "step-cache", "build", map[string]interface{}{"cache-steps": true})
+	s.Require().NoError(err)
+
+	// Nothing changed, so neither step runs again
+	options, err := s.runInFake(fake, dockerOptions, cmdBuild, "step-cache", "build", map[string]interface{}{"cache-steps": true})
+	s.Require().NoError(err)
+	ran, err := ioutil.ReadFile(filepath.Join(options.SourcePath(), "one-ran"))
+	s.Require().NoError(err)
+	s.Equal("ran\n", string(ran), "step one should be cached")
+}
+
+func (s *PipelineSuite) TestSourceDir() {
+	s.NoError(s.run(cmdBuild, "source-path", "build"))
+}
+
+func (s *PipelineSuite) TestDeploy() {
+	s.NoError(s.run(cmdDeploy, "deploy-no-targets", "deploy"))
+}
+
+func (s *PipelineSuite) TestServiceLogs() {
+	options, err := s.runWithOptions(cmdBuild, "service-logs", "build")
+	s.Error(err)
+
+	logs, err := ioutil.ReadFile(options.ServiceLogPath("chatty"))
+	s.Require().NoError(err)
+	s.Contains(string(logs), "chatty service says hello")
+}
+
+func (s *PipelineSuite) TestKeepServices() {
+	fake, dockerOptions, err := fakeruntime.New(filepath.Join(s.WorkingDir(), "sandbox"))
+	s.Require().NoError(err)
+	defer fake.Close()
+	keep := map[string]interface{}{"keep-services": true}
+
+	_, err = s.runInFake(fake, dockerOptions, cmdBuild, "keep-services", "build", keep)
+	s.Require().NoError(err)
+	_, first, err := keptServices(dockerOptions, nil)
+	s.Require().NoError(err)
+	s.Require().Len(first, 1)
+	s.Equal("Up", first[0].Status)
+
+	_, err = s.runInFake(fake, dockerOptions, cmdBuild, "keep-services", "build", keep)
+	s.Require().NoError(err)
+	_, second, err := keptServices(dockerOptions, []string{first[0].Name})
+	s.Require().NoError(err)
+	s.Require().Len(second, 1)
+	s.Equal(first[0].ID, second[0].ID, "the service should have been reused")
+
+	s.NoError(cmdServicesRemove(dockerOptions, nil))
+	_, left, err := keptServices(dockerOptions, nil)
+	s.NoError(err)
+	s.Empty(left)
+}
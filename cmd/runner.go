@@ -16,11 +16,18 @@ package cmd
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"time"
 
+	"github.com/docker/docker/pkg/term"
 	"github.com/pborman/uuid"
 	"github.com/termie/go-shutil"
 	"github.com/wercker/wercker/core"
@@ -80,6 +87,8 @@ type Runner struct {
 	literalLogger *event.LiteralLogHandler
 	metrics       *event.MetricsEventHandler
 	reporter      *event.ReportHandler
+	webhooks      *event.WebhookHandler
+	events        *event.JSONEventHandler
 	getPipeline   pipelineGetter
 	logger        *util.LogEntry
 	emitter       *core.NormalizedEmitter
@@ -128,12 +137,38 @@ func NewRunner(ctx context.Context, options *core.PipelineOptions, dockerOptions
 		r.ListenTo(e)
 	}
 
+	var jh *event.JSONEventHandler
+	if options.EventsJSON != "" {
+		jh, err = event.NewJSONEventHandler(options.EventsJSON)
+		if err != nil {
+			return nil, err
+		}
+		jh.ListenTo(e)
+	}
+
+	// wercker.yml can add webhooks later on
+	var wh *event.WebhookHandler
+	if len(options.Webhooks) > 0 {
+		hooks := []*event.Webhook{}
+		for _, u := range options.Webhooks {
+			hook, err := event.NewWebhook(u, options.WebhookSecret, options.WebhookEvents, "", "")
+			if err != nil {
+				return nil, err
+			}
+			hooks = append(hooks, hook)
+		}
+		wh = event.NewWebhookHandler(hooks)
+		wh.ListenTo(e)
+	}
+
 	return &Runner{
 		options:       options,
 		dockerOptions: dockerOptions,
 		literalLogger: l,
 		metrics:       mh,
 		reporter:      r,
+		webhooks:      wh,
+		events:        jh,
 		getPipeline:   getPipeline,
 		logger:        logger,
 		emitter:       e,
@@ -141,6 +176,14 @@ func NewRunner(ctx context.Context, options *core.PipelineOptions, dockerOptions
 	}, nil
 }
 
+// Close cleans up after the runner once the last event is out
+func (p *Runner) Close() error {
+	if p.events != nil {
+		return p.events.Close()
+	}
+	return nil
+}
+
 // ProjectDir returns the directory where we expect to find the code for this project
 func (p *Runner) ProjectDir() string {
 	if p.options.DirectMount {
@@ -330,9 +373,24 @@ func (p *Runner) CopySource() error {
 	return nil
 }
 
-// GetSession attaches to the container and returns a session.
-func (p *Runner) GetSession(runnerContext context.Context, containerID string) (context.Context, *core.Session, error) {
-	dockerTransport, err := dockerlocal.NewDockerTransport(p.options, p.dockerOptions, containerID)
+// GetSession attaches to the container, or whatever else the box runs in,
+// and returns a session. transport is the pipeline's transport setting.
+func (p *Runner) GetSession(runnerContext context.Context, box core.Box, containerID string, transport string) (context.Context, *core.Session, error) {
+	var dockerTransport core.Transport
+	var err error
+	// Boxes that aren't containers know their own way in
+	if transportBox, ok := box.(core.TransportBox); ok {
+		dockerTransport, err = transportBox.Transport(containerID)
+	} else {
+		switch transport {
+		case "", "attach":
+			dockerTransport, err = dockerlocal.NewDockerTransport(p.options, p.dockerOptions, containerID)
+		case "exec":
+			dockerTransport, err = dockerlocal.NewDockerExecTransport(p.options, p.dockerOptions, containerID)
+		default:
+			err = fmt.Errorf("Unknown transport: %s", transport)
+		}
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -348,6 +406,14 @@ func (p *Runner) GetSession(runnerContext context.Context, containerID string) (
 	return sessionCtx, sess, nil
 }
 
+// pipelineTransport is the transport the named pipeline asks for
+func pipelineTransport(config *core.Config, name string) string {
+	if pipelineConfig, ok := config.PipelinesMap[name]; ok {
+		return pipelineConfig.Transport
+	}
+	return ""
+}
+
 // GetPipeline returns a pipeline based on the "build" config section
 func (p *Runner) GetPipeline(rawConfig *core.Config) (core.Pipeline, error) {
 	return p.getPipeline(rawConfig, p.options, p.dockerOptions)
@@ -362,6 +428,8 @@ type RunnerShared struct {
 	config      *core.Config
 	sessionCtx  context.Context
 	containerID string
+	resumeFrom  int
+	stepKeys    []string
 }
 
 // StartStep emits BuildStepStarted and returns a Finisher for the end event.
@@ -384,10 +452,22 @@ func (p *Runner) StartStep(ctx *RunnerShared, step core.Step, order int) *util.F
 			ArtifactURL:         artifactURL,
 			PackageURL:          r.PackageURL,
 			WerckerYamlContents: r.WerckerYamlContents,
+			Skipped:             r.Skipped,
 		})
 	})
 }
 
+// SkipStep emits the events of a step that doesn't run because its result
+// came from a checkpoint or the cache
+func (p *Runner) SkipStep(ctx *RunnerShared, step core.Step, order int) {
+	finisher := p.StartStep(ctx, step, order)
+	finisher.Finish(&StepResult{
+		Success: true,
+		Message: "Skipped",
+		Skipped: true,
+	})
+}
+
 // StartBuild emits a BuildStarted and returns for a Finisher for the end.
 func (p *Runner) StartBuild(options *core.PipelineOptions) *util.Finisher {
 	p.emitter.Emit(core.BuildStarted, &core.BuildStartedArgs{Options: options})
@@ -456,6 +536,11 @@ func (p *Runner) SetupEnvironment(runnerCtx context.Context) (*RunnerShared, err
 	shared.config = rawConfig
 	sr.WerckerYamlContents = stringConfig
 
+	if err := p.AddWebhooks(rawConfig.Webhooks); err != nil {
+		sr.Message = err.Error()
+		return shared, err
+	}
+
 	// Init the pipeline
 	pipeline, err := p.GetPipeline(rawConfig)
 	if err != nil {
@@ -537,6 +622,25 @@ func (p *Runner) SetupEnvironment(runnerCtx context.Context) (*RunnerShared, err
 		}
 	}
 
+	// Now that the steps know their versions, start from a checkpoint
+	// when rerunning or after the steps we have cached
+	if p.options.ShouldCheckpoint || p.options.ResumeFrom != "" || p.options.ShouldCacheSteps {
+		shared.stepKeys, err = p.stepKeys(pipeline)
+		if err != nil {
+			sr.Message = err.Error()
+			return shared, err
+		}
+	}
+	if p.options.ResumeFrom != "" {
+		shared.resumeFrom, err = p.resume(pipeline, shared.stepKeys)
+	} else if p.options.ShouldCacheSteps {
+		shared.resumeFrom, err = p.cachedSteps(pipeline, shared.stepKeys)
+	}
+	if err != nil {
+		sr.Message = err.Error()
+		return shared, err
+	}
+
 	// Boot up our main container, it will run the services
 	container, err := box.Run(runnerCtx, pipeline.Env())
 	if err != nil {
@@ -567,7 +671,7 @@ func (p *Runner) SetupEnvironment(runnerCtx context.Context) (*RunnerShared, err
 
 	p.logger.Debugln("Attaching session to base box")
 	// Start our session
-	sessionCtx, sess, err := p.GetSession(runnerCtx, container.ID)
+	sessionCtx, sess, err := p.GetSession(runnerCtx, box, container.ID, pipelineTransport(rawConfig, p.options.Pipeline))
 	if err != nil {
 		sr.Message = err.Error()
 		return shared, err
@@ -597,6 +701,172 @@ func (p *Runner) SetupEnvironment(runnerCtx context.Context) (*RunnerShared, err
 	return shared, nil
 }
 
+// checkpointTag is what the checkpoint after the step at index is tagged
+// with, it's the same on every run of the pipeline
+func checkpointTag(pipeline string, index int) string {
+	return fmt.Sprintf("%s-%d", pipeline, index)
+}
+
+// AddWebhooks starts notifying the webhooks from wercker.yml, their URLs
+// and secrets can use the host environment
+func (p *Runner) AddWebhooks(configs []*core.WebhookConfig) error {
+	hooks := []*event.Webhook{}
+	for _, c := range configs {
+		hook, err := event.NewWebhook(p.options.HostEnv.Interpolate(c.URL), p.options.HostEnv.Interpolate(c.Secret), c.Events, c.Template, c.ContentType)
+		if err != nil {
+			return err
+		}
+		hooks = append(hooks, hook)
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+	if p.webhooks == nil {
+		p.webhooks = event.NewWebhookHandler(nil)
+		p.webhooks.ListenTo(p.emitter)
+		// The build started before we knew about any webhooks
+		p.webhooks.BuildStarted(&core.BuildStartedArgs{Options: p.options})
+	}
+	p.webhooks.AddWebhooks(hooks...)
+	return nil
+}
+
+// resume points the box at the checkpoint taken before the step we're
+// rerunning from and returns the index of that step, keys are the cache
+// keys of the steps
+func (p *Runner) resume(pipeline core.Pipeline, keys []string) (int, error) {
+	box, ok := pipeline.Box().(core.CheckpointBox)
+	if !ok {
+		return 0, fmt.Errorf("Can't rerun from a step on box %s", pipeline.Box().GetName())
+	}
+	steps := pipeline.Steps()
+	for i := 1; i < len(steps); i++ {
+		if stepMatches([]string{p.options.ResumeFrom}, steps[i]) {
+			found, err := box.Resume(checkpointTag(p.options.Pipeline, i-1), keys[i-1])
+			if err != nil {
+				return 0, err
+			}
+			if !found {
+				return 0, fmt.Errorf("No checkpoint after step %s with the same steps and source before it, run the pipeline with --checkpoint first", steps[i-1].DisplayName())
+			}
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("No step called %s", p.options.ResumeFrom)
+}
+
+// cacheSkipEnv is the part of the pipeline environment that changes on
+// every run, the source hash covers the commit
+var cacheSkipEnv = map[string]bool{
+	"WERCKER_BUILD_ID":   true,
+	"WERCKER_BUILD_URL":  true,
+	"WERCKER_DEPLOY_ID":  true,
+	"WERCKER_DEPLOY_URL": true,
+	"WERCKER_GIT_COMMIT": true,
+}
+
+// cacheEnv is the environment the steps start with as far as caching goes,
+// the hidden part is left out
+func cacheEnv(pipeline core.Pipeline, box core.CheckpointBox) []string {
+	env := []string{}
+	for _, pair := range pipeline.Env().Ordered() {
+		if !cacheSkipEnv[pair[0]] {
+			env = append(env, fmt.Sprintf("%s=%s", pair[0], pair[1]))
+		}
+	}
+	return append(env, box.BoxEnv(pipeline.Env())...)
+}
+
+// stepCacheKeys chains a key for every step out of the box image, the
+// source, the environment and the step itself, so a change invalidates
+// every step after it
+func stepCacheKeys(imageID, sourceHash string, env []string, steps []core.Step) []string {
+	keys := []string{}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", imageID, sourceHash)
+	for _, kv := range env {
+		fmt.Fprintf(h, "%s\x00", kv)
+	}
+	previous := hex.EncodeToString(h.Sum(nil))
+	for _, step := range steps {
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00", previous, step.ID(), step.Version())
+		data := step.Data()
+		names := []string{}
+		for name := range data {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(h, "%s=%s\x00", name, data[name])
+		}
+		previous = hex.EncodeToString(h.Sum(nil))
+		keys = append(keys, previous)
+	}
+	return keys
+}
+
+// stepKeys works out the cache key of every step, there are none if the
+// box can't checkpoint
+func (p *Runner) stepKeys(pipeline core.Pipeline) ([]string, error) {
+	box, ok := pipeline.Box().(core.CheckpointBox)
+	if !ok {
+		return nil, nil
+	}
+	sourceHash, err := util.HashDir(p.ProjectDir(), serviceCacheSkip)
+	if err != nil {
+		return nil, err
+	}
+	return stepCacheKeys(box.ImageID(), sourceHash, cacheEnv(pipeline, box), pipeline.Steps()), nil
+}
+
+// cachedSteps points the box at the image of the last step that's cached
+// under its key in keys, it returns the index of the first step that still
+// has to run
+func (p *Runner) cachedSteps(pipeline core.Pipeline, keys []string) (int, error) {
+	box, ok := pipeline.Box().(core.CheckpointBox)
+	if !ok {
+		p.logger.Warnln("Can't cache steps on box", pipeline.Box().GetName())
+		return 0, nil
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		found, err := box.Resume(keys[i], keys[i])
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			return i + 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// Checkpoint saves the state of the box after the step at index under
+// tags, along with the environment the steps exported
+func (p *Runner) Checkpoint(shared *RunnerShared, index int, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	box, ok := shared.box.(core.CheckpointBox)
+	if !ok || shared.stepKeys == nil {
+		return fmt.Errorf("Can't checkpoint box %s", shared.box.GetName())
+	}
+	if err := dockerlocal.SaveEnvironment(shared.sessionCtx, shared.sess, p.options, shared.pipeline.Env()); err != nil {
+		return err
+	}
+	return box.Checkpoint(shared.stepKeys[index], shared.pipeline.Steps()[index], tags...)
+}
+
+// PruneCache removes the cached steps this run and the latest other runs
+// of the pipeline don't use
+func (p *Runner) PruneCache(shared *RunnerShared) error {
+	box, ok := shared.box.(core.CheckpointBox)
+	if !ok || !p.options.ShouldCacheSteps || shared.stepKeys == nil {
+		return nil
+	}
+	return box.PruneCache(shared.stepKeys, p.options.CacheStepsKeep)
+}
+
 // StepResult holds the info we need to report on steps
 type StepResult struct {
 	Success             bool
@@ -605,6 +875,47 @@ type StepResult struct {
 	Message             string
 	ExitCode            int
 	WerckerYamlContents string
+	Skipped             bool
+}
+
+// stepMatches tells us if step is one of names, they can be the display
+// name or the id of the step. The name is left out, every script step is
+// called script.
+func stepMatches(names []string, step core.Step) bool {
+	for _, name := range names {
+		if name == step.DisplayName() || name == step.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// breakpoint pauses the pipeline with a shell in the box that has the
+// environment of step, we carry on once the shell exits
+func (p *Runner) breakpoint(shared *RunnerShared, step core.Step, message string) {
+	// Nobody could use the shell, in CI say
+	if !term.IsTerminal(os.Stdin.Fd()) {
+		p.logger.Warnln("No terminal to attach a shell to, skipping breakpoint at", step.DisplayName())
+		return
+	}
+	p.logger.Println(p.formatter.Info(message, step.DisplayName()))
+	err := shared.box.AttachInteractive(p.stepCwd(shared, step), shared.pipeline, step)
+	if err != nil {
+		p.logger.WithField("Error", err).Warn("Unable to attach shell")
+	}
+	p.logger.Println(p.formatter.Info("Continuing", step.DisplayName()))
+}
+
+// stepCwd is the directory step runs in, its cwd is relative to the source
+func (p *Runner) stepCwd(shared *RunnerShared, step core.Step) string {
+	cwd := shared.pipeline.Env().Interpolate(step.Cwd())
+	if cwd == "" {
+		return p.options.SourcePath()
+	}
+	if path.IsAbs(cwd) {
+		return cwd
+	}
+	return path.Join(p.options.SourcePath(), cwd)
 }
 
 // RunStep runs a step and tosses error if it fails
@@ -632,7 +943,16 @@ func (p *Runner) RunStep(shared *RunnerShared, step core.Step, order int) (*Step
 		p.logger.Debugln(" ", pair[0], pair[1])
 	}
 
+	if step.Debug() || stepMatches(p.options.BreakBefore, step) {
+		p.breakpoint(shared, step, "Breakpoint before")
+	}
+
+	// Asking resets the count, so only kills during this step are blamed on
+	// it below
+	shared.box.OOMKilled()
+
 	exit, err := step.Execute(shared.sessionCtx, shared.sess)
+	recovered := false
 	if exit != 0 {
 		sr.ExitCode = exit
 		if p.options.AttachOnError {
@@ -641,12 +961,17 @@ func (p *Runner) RunStep(shared *RunnerShared, step core.Step, order int) (*Step
 				shared.pipeline,
 				step,
 			)
+			recovered = true
 		}
 	} else if err == nil {
 		sr.Success = true
 		sr.ExitCode = 0
 	}
 
+	if !recovered && stepMatches(p.options.BreakAfter, step) {
+		p.breakpoint(shared, step, "Breakpoint after")
+	}
+
 	// Grab the message
 	var message bytes.Buffer
 	messageErr := step.CollectFile(shared.containerID, step.ReportPath(), "message.txt", &message)
@@ -657,6 +982,21 @@ func (p *Runner) RunStep(shared *RunnerShared, step core.Step, order int) (*Step
 	}
 	sr.Message = message.String()
 
+	// A kill by the OOM killer just looks like any other non-zero exit,
+	// make it obvious what happened
+	if !sr.Success && shared.box.OOMKilled() {
+		sr.Message = "Step was killed because the box ran out of memory"
+		if err == nil {
+			err = errors.New(sr.Message)
+		} else {
+			err = fmt.Errorf("%s: %s", sr.Message, err)
+		}
+	}
+
+	if !sr.Success {
+		p.EmitServiceLogs(shared)
+	}
+
 	// This is the error from the step.Execute above
 	if err != nil {
 		if sr.Message == "" {
@@ -687,3 +1027,63 @@ func (p *Runner) RunStep(shared *RunnerShared, step core.Step, order int) (*Step
 	}
 	return sr, nil
 }
+
+// serviceLogTail is how many lines of each service's logs we show when a
+// step fails
+const serviceLogTail = 20
+
+// EmitServiceLogs shows the end of every service's logs, a failing step
+// often has more to do with a service than with the step itself
+func (p *Runner) EmitServiceLogs(shared *RunnerShared) {
+	for _, service := range shared.box.Services() {
+		tail, err := util.TailFile(service.LogPath(), serviceLogTail)
+		if err != nil {
+			p.logger.WithField("Error", err).Debugln("Unable to read service logs", service.GetName())
+			continue
+		}
+		if tail == "" {
+			continue
+		}
+		p.emitter.Emit(core.Logs, &core.LogsArgs{
+			Stream: "stderr",
+			Logs:   fmt.Sprintf("Last logs of service %s:\n%s", service.GetName(), tail),
+		})
+	}
+}
+
+// serviceLogWait is how long we wait for stopped services to finish
+// writing their logs
+const serviceLogWait = 10 * time.Second
+
+// StoreServiceLogs uploads the logs of every service as an artifact, it has
+// to run after the box was stopped to get the complete logs
+func (p *Runner) StoreServiceLogs(shared *RunnerShared) error {
+	artificer := dockerlocal.NewArtificer(p.options, p.dockerOptions)
+	timeout := time.After(serviceLogWait)
+	for _, service := range shared.box.Services() {
+		// Kept services are still running, store what they logged so far
+		if !p.options.KeepServices {
+			select {
+			case <-service.LogsDone():
+			case <-timeout:
+				p.logger.Warnln("Timed out waiting for the logs of service", service.GetName())
+			}
+		}
+		artifact := &core.Artifact{
+			HostPath:      service.LogPath(),
+			ApplicationID: p.options.ApplicationID,
+			BuildID:       p.options.BuildID,
+			DeployID:      p.options.DeployID,
+			Bucket:        p.options.S3Bucket,
+			ContentType:   "text/plain",
+		}
+		if _, err := os.Stat(artifact.HostPath); err != nil {
+			continue
+		}
+		artifact.Key = path.Join(path.Dir(artifact.RemotePath()), "services", path.Base(artifact.HostPath))
+		if err := artificer.Upload(artifact); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+)
+
+type RunnerSuite struct {
+	*util.TestSuite
+}
+
+func TestRunnerSuite(t *testing.T) {
+	suiteTester := &RunnerSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *RunnerSuite) TestStepMatches() {
+	step, err := core.NewStep(&core.StepConfig{ID: "wercker/hipchat-notify@1.0", Name: "tell the room"}, core.EmptyPipelineOptions())
+	s.Require().NoError(err)
+
+	s.True(stepMatches([]string{"tell the room"}, step))
+	s.True(stepMatches([]string{"other", "tell the room"}, step))
+	s.False(stepMatches([]string{"hipchat-notify"}, step), "names are shared by too many steps")
+	s.True(stepMatches([]string{"wercker/hipchat-notify@1.0"}, step))
+	s.False(stepMatches([]string{"notify"}, step))
+	s.False(stepMatches(nil, step))
+}
+
+func (s *RunnerSuite) TestStepCacheKeys() {
+	options := core.EmptyPipelineOptions()
+	newStep := func(code string) core.Step {
+		step, err := core.NewStep(&core.StepConfig{ID: "script", Data: map[string]string{"code": code}}, options)
+		s.Require().NoError(err)
+		return step
+	}
+
+	env := []string{"FOO=bar"}
+	keys := stepCacheKeys("image", "source", env, []core.Step{newStep("make"), newStep("make test")})
+	s.Len(keys, 2)
+	s.Equal(keys, stepCacheKeys("image", "source", env, []core.Step{newStep("make"), newStep("make test")}))
+
+	changed := stepCacheKeys("image", "source", env, []core.Step{newStep("make"), newStep("make check")})
+	s.Equal(keys[0], changed[0])
+	s.NotEqual(keys[1], changed[1])
+
+	// Anything that changes a step changes every step after it
+	changed = stepCacheKeys("image", "other", env, []core.Step{newStep("make"), newStep("make test")})
+	s.NotEqual(keys[0], changed[0])
+	s.NotEqual(keys[1], changed[1])
+
+	changed = stepCacheKeys("image", "source", []string{"FOO=baz"}, []core.Step{newStep("make"), newStep("make test")})
+	s.NotEqual(keys[0], changed[0])
+	s.NotEqual(keys[1], changed[1])
+}
@@ -0,0 +1,95 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/wercker/wercker/docker"
+)
+
+// keptServices lists the services left behind by --keep-services, filtered
+// down to the given container or project names if there are any
+func keptServices(dockerOptions *dockerlocal.DockerOptions, names []string) (dockerlocal.ContainerRuntime, []*dockerlocal.KeptService, error) {
+	client, err := dockerlocal.NewContainerRuntime(dockerOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	services, err := dockerlocal.ListKeptServices(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(names) == 0 {
+		return client, services, nil
+	}
+
+	selected := []*dockerlocal.KeptService{}
+	for _, name := range names {
+		found := false
+		for _, service := range services {
+			if service.Name == name || service.Project == name {
+				selected = append(selected, service)
+				found = true
+			}
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("No kept service or project called %s", name)
+		}
+	}
+	return client, selected, nil
+}
+
+func cmdServicesList(dockerOptions *dockerlocal.DockerOptions, out io.Writer) error {
+	_, services, err := keptServices(dockerOptions, nil)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPROJECT\tIMAGE\tSTATUS")
+	for _, service := range services {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", service.Name, service.Project, service.Image, service.Status)
+	}
+	return w.Flush()
+}
+
+func cmdServicesStop(dockerOptions *dockerlocal.DockerOptions, names []string) error {
+	client, services, err := keptServices(dockerOptions, names)
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		cliLogger.Println("Stopping", service.Name)
+		if err := dockerlocal.StopKeptService(client, service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdServicesRemove(dockerOptions *dockerlocal.DockerOptions, names []string) error {
+	client, services, err := keptServices(dockerOptions, names)
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		cliLogger.Println("Removing", service.Name)
+		if err := dockerlocal.RemoveKeptService(client, service); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -30,10 +30,38 @@ type Box interface {
 	GetTag() string
 	Clean() error
 	Stop()
-	Commit(string, string, string) (*docker.Image, error)
+	Commit(context.Context, string, string, string) (*docker.Image, error)
 	Restart() (*docker.Container, error)
 	AddService(ServiceBox)
+	Services() []ServiceBox
 	Fetch(context.Context, *util.Environment) (*docker.Image, error)
 	Run(context.Context, *util.Environment) (*docker.Container, error)
 	RecoverInteractive(string, Pipeline, Step) error
+	AttachInteractive(string, Pipeline, Step) error
+	OOMKilled() bool
+}
+
+// CheckpointBox is a Box that can save its state after a step under some
+// tags and start from that state again in a later run
+type CheckpointBox interface {
+	Box
+	ImageID() string
+	BoxEnv(env *util.Environment) []string
+	Checkpoint(key string, step Step, tags ...string) error
+	Resume(tag, key string) (bool, error)
+	Resumed() bool
+	PruneCache(keys []string, runs int) error
+}
+
+// Resumed is whether box starts from a checkpoint rather than its image
+func Resumed(box Box) bool {
+	cb, ok := box.(CheckpointBox)
+	return ok && cb.Resumed()
+}
+
+// TransportBox is a Box that brings its own Transport instead of being
+// attached to like a container
+type TransportBox interface {
+	Box
+	Transport(containerID string) (Transport, error)
 }
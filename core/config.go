@@ -43,11 +43,57 @@ type BoxConfig struct {
 	Registry   string
 	Entrypoint string
 	URL        string
+
+	// Build the box from a Dockerfile instead of using an existing image,
+	// paths are relative to the project
+	Dockerfile string
+	Context    string
+	BuildArgs  map[string]string `yaml:"build-args"`
+
+	// Resource limits, sizes take docker style units like "512m"
+	Memory    string
+	CPUs      float64           `yaml:"cpus"`
+	PidsLimit int64             `yaml:"pids-limit"`
+	ShmSize   string            `yaml:"shm-size"`
+	Ulimits   map[string]string `yaml:"ulimits"`
+
+	// Extra mounts and privileges, anything that reaches into the host
+	// needs --allow-privileged
+	Volumes    []string
+	Tmpfs      []string
+	CapAdd     []string `yaml:"cap-add"`
+	CapDrop    []string `yaml:"cap-drop"`
+	Privileged bool
+	Devices    []string
+
+	// Healthcheck and DependsOn only apply to services, DependsOn lists
+	// other services by name or ID
+	Healthcheck *HealthcheckConfig
+	DependsOn   []string `yaml:"depends-on"`
+}
+
+// HealthcheckConfig tells us how to find out a service is ready, set one of
+// TCP, HTTP or Cmd. Timeout and Interval are durations like "30s". Image is
+// what TCP and HTTP probes run in, it needs nc and wget.
+type HealthcheckConfig struct {
+	TCP      int
+	HTTP     string
+	Cmd      string
+	Timeout  string
+	Interval string
+	Image    string
 }
 
-// IsExternal tells us if the box (service) is located on disk
+// IsExternal tells us if the box (service) is a wercker project we build
+// ourselves, either on disk or in a git repository
 func (c *BoxConfig) IsExternal() bool {
-	return c.URL != "" && strings.HasPrefix(c.URL, "file://")
+	return c.URL != "" && (strings.HasPrefix(c.URL, "file://") || c.IsGit())
+}
+
+// IsGit tells us if the box (service) needs to be cloned from git first,
+// the URL looks like git+https://host/repo.git@ref#pipeline
+func (c *BoxConfig) IsGit() bool {
+	return strings.HasPrefix(c.URL, "git+ssh://") || strings.HasPrefix(c.URL, "git+https://")
 }
 
 // UnmarshalYAML first attempts to unmarshal as a string to ID otherwise
@@ -72,6 +118,9 @@ type StepConfig struct {
 	Cwd  string
 	Name string
 	Data map[string]string
+
+	// Debug pauses the pipeline with a shell before the step runs
+	Debug bool
 }
 
 // ifaceToString takes a value from yaml and makes it a string (currently
@@ -149,6 +198,11 @@ func (r *RawStepConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		r.Name = v
 		delete(stepData, "name")
 	}
+	// Steps can have a debug property of their own
+	if v, ok := stepData["wercker-debug"]; ok {
+		r.Debug = v == "true"
+		delete(stepData, "wercker-debug")
+	}
 	r.Data = stepData
 	return nil
 }
@@ -171,6 +225,9 @@ type PipelineConfig struct {
 	AfterSteps RawStepsConfig `yaml:"after-steps"`
 	StepsMap   map[string][]*RawStepConfig
 	Services   []*RawBoxConfig `yaml:"services"`
+	// Transport is how commands get to the box, "attach" (the default) or
+	// "exec"
+	Transport string `yaml:"transport"`
 }
 
 var pipelineReservedWords = map[string]struct{}{
@@ -178,6 +235,7 @@ var pipelineReservedWords = map[string]struct{}{
 	"services":    struct{}{},
 	"steps":       struct{}{},
 	"after-steps": struct{}{},
+	"transport":   struct{}{},
 }
 
 // UnmarshalYAML in this case is a little involved due to the myriad shapes our
@@ -224,14 +282,26 @@ func (r *RawPipelineConfig) UnmarshalYAML(unmarshal func(interface{}) error) err
 
 // Config is the data type for wercker.yml
 type Config struct {
-	Box               *RawBoxConfig   `yaml:"box"`
-	CommandTimeout    int             `yaml:"command-timeout"`
-	NoResponseTimeout int             `yaml:"no-response-timeout"`
-	Services          []*RawBoxConfig `yaml:"services"`
-	SourceDir         string          `yaml:"source-dir"`
+	Box               *RawBoxConfig    `yaml:"box"`
+	CommandTimeout    int              `yaml:"command-timeout"`
+	NoResponseTimeout int              `yaml:"no-response-timeout"`
+	Services          []*RawBoxConfig  `yaml:"services"`
+	SourceDir         string           `yaml:"source-dir"`
+	Webhooks          []*WebhookConfig `yaml:"webhooks"`
 	PipelinesMap      map[string]*RawPipelineConfig
 }
 
+// WebhookConfig is a URL to tell about the build, the secret signs the
+// payloads and can name an environment variable like $HOOK_SECRET. The
+// content type goes with the template.
+type WebhookConfig struct {
+	URL         string   `yaml:"url"`
+	Secret      string   `yaml:"secret"`
+	Events      []string `yaml:"events"`
+	Template    string   `yaml:"template"`
+	ContentType string   `yaml:"content-type"`
+}
+
 // RawConfig is the unwrapper for Config
 type RawConfig struct {
 	*Config
@@ -243,6 +313,7 @@ var configReservedWords = map[string]struct{}{
 	"no-response-timeout": struct{}{},
 	"services":            struct{}{},
 	"source-dir":          struct{}{},
+	"webhooks":            struct{}{},
 }
 
 // UnmarshalYAML in this case is a little involved due to the myriad shapes our
@@ -48,11 +48,59 @@ func (s *ConfigSuite) TestConfigBoxStructs() {
 	s.Equal("structs_box", config.Box.ID)
 	s.Equal("structs_service", config.Services[0].ID)
 
+	s.Equal("512m", config.Box.Memory)
+	s.Equal(1.5, config.Box.CPUs)
+	s.Equal(int64(100), config.Box.PidsLimit)
+	s.Equal("64m", config.Box.ShmSize)
+	s.Equal("1024:2048", config.Box.Ulimits["nofile"])
+	s.Equal("64", config.Box.Ulimits["nproc"])
+	s.Equal("256m", config.Services[0].Memory)
+	s.Require().NotNil(config.Services[0].Healthcheck)
+	s.Equal(5432, config.Services[0].Healthcheck.TCP)
+	s.Equal("30s", config.Services[0].Healthcheck.Timeout)
+	s.Equal("mirror.example.com/busybox:1", config.Services[0].Healthcheck.Image)
+	s.Equal([]string{"structs_service"}, config.Services[1].DependsOn)
+	s.Require().Len(config.Webhooks, 1)
+	s.Equal("https://example.com/hook", config.Webhooks[0].URL)
+	s.Equal("$HOOK_SECRET", config.Webhooks[0].Secret)
+	s.Equal([]string{"fullPipelineFinished"}, config.Webhooks[0].Events)
+	s.Equal("text/plain", config.Webhooks[0].ContentType)
+	s.NotContains(config.PipelinesMap, "webhooks")
+
+	fromDockerfile := config.PipelinesMap["from-dockerfile"]
+	s.Equal("ci/Dockerfile", fromDockerfile.Box.Dockerfile)
+	s.Equal(".", fromDockerfile.Box.Context)
+	s.Equal("1.7", fromDockerfile.Box.BuildArgs["GO_VERSION"])
+
 	pipeline := config.PipelinesMap["pipeline"]
 	s.Equal(pipeline.Box.ID, "blue")
 	s.Equal(pipeline.Steps[0].ID, "string-step")
 	s.Equal(pipeline.Steps[1].ID, "script")
 	s.Equal(pipeline.Steps[2].ID, "script")
+	s.True(pipeline.Steps[1].Debug)
+	s.False(pipeline.Steps[2].Debug)
+	s.NotContains(pipeline.Steps[1].Data, "wercker-debug")
+	s.Equal("verbose", pipeline.Steps[1].Data["debug"], "the step's own debug property is left alone")
+}
+
+func (s *ConfigSuite) TestBoxIsExternal() {
+	tests := []struct {
+		url      string
+		external bool
+		git      bool
+	}{
+		{"", false, false},
+		{"file://../api#dev", true, false},
+		{"git+https://github.com/wercker/api.git@v1#dev", true, true},
+		{"git+ssh://git@github.com/wercker/api.git", true, true},
+		{"https://github.com/wercker/api.git", false, false},
+	}
+
+	for _, test := range tests {
+		config := &BoxConfig{URL: test.url}
+		s.Equal(test.external, config.IsExternal(), test.url)
+		s.Equal(test.git, config.IsGit(), test.url)
+	}
 }
 
 func (s *ConfigSuite) TestIfaceToString() {
@@ -110,6 +110,8 @@ type BuildStepFinishedArgs struct {
 	PackageURL string
 	// Only applicable to the setup environment step
 	WerckerYamlContents string
+	// Skipped steps came from a checkpoint or the cache without running
+	Skipped bool
 }
 
 // FullPipelineFinishedArgs contains the args associated with the
@@ -41,6 +41,7 @@ type GlobalOptions struct {
 	Debug      bool
 	Journal    bool
 	Verbose    bool
+	Quiet      bool
 	ShowColors bool
 
 	// Auth
@@ -74,6 +75,7 @@ func NewGlobalOptions(c util.Settings, e *util.Environment) (*GlobalOptions, err
 	debug, _ := c.GlobalBool("debug")
 	journal, _ := c.GlobalBool("journal")
 	verbose, _ := c.GlobalBool("verbose")
+	quiet, _ := c.GlobalBool("quiet")
 	// TODO(termie): switch negative flag
 	showColors, _ := c.GlobalBool("no-colors")
 	showColors = !showColors
@@ -93,6 +95,7 @@ func NewGlobalOptions(c util.Settings, e *util.Environment) (*GlobalOptions, err
 		Debug:      debug,
 		Journal:    journal,
 		Verbose:    verbose,
+		Quiet:      quiet,
 		ShowColors: showColors,
 
 		AuthToken:      authToken,
@@ -282,6 +285,12 @@ type ReporterOptions struct {
 	ReporterHost string
 	ReporterKey  string
 	ShouldReport bool
+	// EventsJSON is where to write the events as JSON lines, if anywhere
+	EventsJSON string
+	// Webhooks are told about WebhookEvents, signed with WebhookSecret
+	Webhooks      []string
+	WebhookSecret string
+	WebhookEvents []string
 }
 
 // NewReporterOptions constructor
@@ -289,6 +298,10 @@ func NewReporterOptions(c util.Settings, e *util.Environment, globalOpts *Global
 	shouldReport, _ := c.Bool("report")
 	reporterHost, _ := c.String("wercker-host")
 	reporterKey, _ := c.String("wercker-token")
+	eventsJSON, _ := c.String("events-json")
+	webhooks, _ := c.StringSlice("webhook")
+	webhookSecret, _ := c.String("webhook-secret")
+	webhookEvents, _ := c.StringSlice("webhook-events")
 
 	if shouldReport {
 		if reporterKey == "" {
@@ -305,6 +318,10 @@ func NewReporterOptions(c util.Settings, e *util.Environment, globalOpts *Global
 		ReporterHost:  reporterHost,
 		ReporterKey:   reporterKey,
 		ShouldReport:  shouldReport,
+		EventsJSON:    eventsJSON,
+		Webhooks:      webhooks,
+		WebhookSecret: webhookSecret,
+		WebhookEvents: webhookEvents,
 	}, nil
 }
 
@@ -336,9 +353,20 @@ type PipelineOptions struct {
 	Repository       string
 	Tag              string
 	Message          string
+	ShouldSquash     bool
 	ShouldStoreLocal bool
 	ShouldStoreS3    bool
 
+	// ShouldCheckpoint commits the box after every step, ResumeFrom names
+	// the step to start from using those commits
+	ShouldCheckpoint bool
+	ResumeFrom       string
+
+	// ShouldCacheSteps skips the steps that ran the same way before,
+	// CacheStepsKeep is how many runs of the pipeline to keep them for
+	ShouldCacheSteps bool
+	CacheStepsKeep   int
+
 	WorkingDir string
 
 	GuestRoot  string
@@ -355,11 +383,16 @@ type PipelineOptions struct {
 	ShouldRemove      bool
 	SourceDir         string
 
-	AttachOnError  bool
-	DirectMount    bool
-	EnableDevSteps bool
-	PublishPorts   []string
-	WerckerYml     string
+	AttachOnError   bool
+	BreakBefore     []string
+	BreakAfter      []string
+	DirectMount     bool
+	EnableDevSteps  bool
+	AllowPrivileged bool
+	KeepServices    bool
+	RebuildServices bool
+	PublishPorts    []string
+	WerckerYml      string
 }
 
 func guessApplicationID(c util.Settings, e *util.Environment, name string) string {
@@ -528,6 +561,11 @@ func NewPipelineOptions(c util.Settings, e *util.Environment) (*PipelineOptions,
 	shouldCommit := (repository != "")
 	tag := guessTag(c, e)
 	message := guessMessage(c, e)
+	shouldSquash, _ := c.Bool("squash")
+	shouldCheckpoint, _ := c.Bool("checkpoint")
+	resumeFrom, _ := c.String("from")
+	shouldCacheSteps, _ := c.Bool("cache-steps")
+	cacheStepsKeep, _ := c.Int("cache-steps-keep")
 	shouldStoreLocal, _ := c.Bool("store-local")
 	shouldStoreS3, _ := c.Bool("store-s3")
 
@@ -559,8 +597,13 @@ func NewPipelineOptions(c util.Settings, e *util.Environment) (*PipelineOptions,
 	sourceDir, _ := c.String("source-dir")
 
 	attachOnError, _ := c.Bool("attach-on-error")
+	breakBefore, _ := c.StringSlice("break-before")
+	breakAfter, _ := c.StringSlice("break-after")
 	directMount, _ := c.Bool("direct-mount")
 	enableDevSteps, _ := c.Bool("enable-dev-steps")
+	allowPrivileged, _ := c.Bool("allow-privileged")
+	keepServices, _ := c.Bool("keep-services")
+	rebuildServices, _ := c.Bool("rebuild-services")
 	publishPorts, _ := c.StringSlice("publish")
 	werckerYml, _ := c.String("wercker-yml")
 
@@ -589,8 +632,13 @@ func NewPipelineOptions(c util.Settings, e *util.Environment) (*PipelineOptions,
 		Tag:              tag,
 		Repository:       repository,
 		ShouldCommit:     shouldCommit,
+		ShouldSquash:     shouldSquash,
 		ShouldStoreLocal: shouldStoreLocal,
 		ShouldStoreS3:    shouldStoreS3,
+		ShouldCheckpoint: shouldCheckpoint,
+		ResumeFrom:       resumeFrom,
+		ShouldCacheSteps: shouldCacheSteps,
+		CacheStepsKeep:   cacheStepsKeep,
 
 		WorkingDir: workingDir,
 
@@ -608,11 +656,16 @@ func NewPipelineOptions(c util.Settings, e *util.Environment) (*PipelineOptions,
 		ShouldRemove:      shouldRemove,
 		SourceDir:         sourceDir,
 
-		AttachOnError:  attachOnError,
-		DirectMount:    directMount,
-		EnableDevSteps: enableDevSteps,
-		PublishPorts:   publishPorts,
-		WerckerYml:     werckerYml,
+		AttachOnError:   attachOnError,
+		BreakBefore:     breakBefore,
+		BreakAfter:      breakAfter,
+		DirectMount:     directMount,
+		EnableDevSteps:  enableDevSteps,
+		AllowPrivileged: allowPrivileged,
+		KeepServices:    keepServices,
+		RebuildServices: rebuildServices,
+		PublishPorts:    publishPorts,
+		WerckerYml:      werckerYml,
 	}, nil
 }
 
@@ -641,6 +694,11 @@ func (o *PipelineOptions) ReportPath(s ...string) string {
 	return path.Join(o.ReportRoot, path.Join(s...))
 }
 
+// ServiceLogPath returns where the logs of a service are kept on the host
+func (o *PipelineOptions) ServiceLogPath(name string) string {
+	return path.Join(o.BuildPath(), o.PipelineID+"-services", name+".log")
+}
+
 // ContainerPath returns the path where exported containers live
 func (o *PipelineOptions) ContainerPath() string {
 	return path.Join(o.WorkingDir, "_containers")
@@ -192,7 +192,8 @@ func (p *BasePipeline) SetupGuest(sessionCtx context.Context, sess *Session) err
 
 	cmds := []string{}
 
-	if !p.options.DirectMount {
+	// A resumed pipeline already has them from its checkpoint
+	if !p.options.DirectMount && !Resumed(p.box) {
 		cmds = append(cmds,
 			// Make sure our guest path exists
 			fmt.Sprintf(`mkdir -p "%s"`, p.options.GuestPath()),
@@ -22,9 +22,13 @@ import (
 
 // ServiceBox interface to services
 type ServiceBox interface {
-	Run(context.Context, *util.Environment, []string) (*docker.Container, error)
+	Run(context.Context, *util.Environment, string) (*docker.Container, error)
 	Fetch(ctx context.Context, env *util.Environment) (*docker.Image, error)
-	Link() string
+	Aliases() []string
+	DependsOn() []string
+	WaitReady(context.Context) error
+	LogPath() string
+	LogsDone() <-chan struct{}
 	GetID() string
 	GetName() string
 }
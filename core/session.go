@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pborman/uuid"
@@ -26,20 +27,28 @@ import (
 	"golang.org/x/net/context"
 )
 
+// Output is something the container wrote to one of its streams
+type Output struct {
+	Stream string
+	Logs   string
+}
+
 // Receiver is for reading from our session
 type Receiver struct {
-	queue chan string
+	queue  chan *Output
+	stream string
 }
 
-// NewReceiver returns a new channel-based io.Writer
-func NewReceiver(queue chan string) *Receiver {
-	return &Receiver{queue: queue}
+// NewReceiver returns a new channel-based io.Writer for stream, either
+// "stdout" or "stderr"
+func NewReceiver(queue chan *Output, stream string) *Receiver {
+	return &Receiver{queue: queue, stream: stream}
 }
 
 // Write writes to a channel
 func (r *Receiver) Write(p []byte) (int, error) {
 	buf := bytes.NewBuffer(p)
-	r.queue <- buf.String()
+	r.queue <- &Output{Stream: r.stream, Logs: buf.String()}
 	return buf.Len(), nil
 }
 
@@ -65,15 +74,44 @@ type Transport interface {
 	Attach(context.Context, io.Reader, io.Writer, io.Writer) (context.Context, error)
 }
 
+// CommandTransport is a Transport that runs every command on its own, so
+// the session doesn't need to type into a shell and wait for a sentinel.
+// RunCommand returns the exit code of the command. When the context is done
+// it stops the command before it returns, commands are run one at a time.
+type CommandTransport interface {
+	Transport
+	RunCommand(context.Context, string, io.Writer, io.Writer) (int, error)
+}
+
 // Session is our way to interact with the docker container
 type Session struct {
 	options    *PipelineOptions
 	transport  Transport
 	logsHidden bool
 	send       chan string
-	recv       chan string
+	recv       chan *Output
 	exit       chan int
 	logger     *util.LogEntry
+
+	// CommandTransports run one command at a time, in order, since every
+	// command starts from the state the one before it left behind
+	queue     chan *queuedCommand
+	queueOnce sync.Once
+}
+
+// queuedCommand is waiting for its turn to run on a CommandTransport, done
+// gets the result if anybody wants it
+type queuedCommand struct {
+	ctx     context.Context
+	command string
+	stdout  io.Writer
+	stderr  io.Writer
+	done    chan commandResult
+}
+
+type commandResult struct {
+	exit int
+	err  error
 }
 
 // NewSession returns a new interactive session to a container.
@@ -91,7 +129,7 @@ func (s *Session) Transport() interface{} {
 	return s.transport
 }
 
-func (s *Session) Recv() chan string {
+func (s *Session) Recv() chan *Output {
 	return s.recv
 }
 
@@ -99,8 +137,9 @@ func (s *Session) Recv() chan string {
 // Returns a context object for the transport so we can propagate cancels
 // on errors and closed connections.
 func (s *Session) Attach(runnerCtx context.Context) (context.Context, error) {
-	recv := make(chan string)
-	outputStream := NewReceiver(recv)
+	recv := make(chan *Output)
+	outputStream := NewReceiver(recv, "stdout")
+	errorStream := NewReceiver(recv, "stderr")
 	s.recv = recv
 
 	send := make(chan string)
@@ -108,7 +147,7 @@ func (s *Session) Attach(runnerCtx context.Context) (context.Context, error) {
 	s.send = send
 
 	// We treat the transport context as the session context everywhere
-	return s.transport.Attach(runnerCtx, inputStream, outputStream, outputStream)
+	return s.transport.Attach(runnerCtx, inputStream, outputStream, errorStream)
 }
 
 // HideLogs will emit Logs with args.Hidden set to true
@@ -137,6 +176,23 @@ func (s *Session) Send(sessionCtx context.Context, forceHidden bool, commands ..
 		// Pass
 	}
 
+	if t, ok := s.transport.(CommandTransport); ok {
+		command := strings.Join(commands, "\n") + "\n"
+		e.Emit(Logs, &LogsArgs{
+			Hidden: s.logsHidden || forceHidden,
+			Stream: "stdin",
+			Logs:   command,
+		})
+		// Nobody is waiting on this one, whatever it prints goes to Recv()
+		s.enqueue(t, &queuedCommand{
+			ctx:     sessionCtx,
+			command: command,
+			stdout:  NewReceiver(s.recv, "stdout"),
+			stderr:  NewReceiver(s.recv, "stderr"),
+		})
+		return nil
+	}
+
 	for i := range commands {
 		command := commands[i] + "\n"
 		select {
@@ -230,6 +286,10 @@ func smartSplitLines(line, sentinel string) []string {
 // Ways for a command to be successful:
 //  [x] We received the sentinel echo with exit code 0
 func (s *Session) SendChecked(sessionCtx context.Context, commands ...string) (int, []string, error) {
+	if t, ok := s.transport.(CommandTransport); ok {
+		return s.runChecked(sessionCtx, t, commands...)
+	}
+
 	e, err := EmitterFromContext(sessionCtx)
 	if err != nil {
 		return -1, []string{}, err
@@ -290,10 +350,20 @@ func (s *Session) SendChecked(sessionCtx context.Context, commands ...string) (i
 	go func() {
 		for {
 			select {
-			case line := <-s.recv:
+			case out := <-s.recv:
 				// If we found a line reset the NoResponseTimeout timer
 				noResponseTimeout <- struct{}{}
-				lines := smartSplitLines(line, sentinel)
+				// The sentinel only ever shows up on stdout, and stderr isn't
+				// part of the command's output
+				if out.Stream == "stderr" {
+					e.Emit(Logs, &LogsArgs{
+						Hidden: s.logsHidden,
+						Stream: out.Stream,
+						Logs:   out.Logs,
+					})
+					continue
+				}
+				lines := smartSplitLines(out.Logs, sentinel)
 				for _, subline := range lines {
 					// subline = fmt.Sprintf("%s\n", subline)
 					// If we found the exit code, we're done
@@ -336,3 +406,125 @@ func (s *Session) SendChecked(sessionCtx context.Context, commands ...string) (i
 	}
 	return r.exit, r.recv, r.err
 }
+
+// commandOutput emits what a command writes to one of its streams and
+// keeps stdout around for the caller
+type commandOutput struct {
+	session  *Session
+	emitter  *NormalizedEmitter
+	stream   string
+	activity chan struct{}
+	lock     *sync.Mutex
+	recv     *[]string
+	done     bool
+}
+
+func (o *commandOutput) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	// The command has been given up on, drop whatever it still writes
+	if o.done {
+		return len(p), nil
+	}
+	select {
+	case o.activity <- struct{}{}:
+	default:
+	}
+	line := string(p)
+	o.emitter.Emit(Logs, &LogsArgs{
+		Hidden: o.session.logsHidden,
+		Stream: o.stream,
+		Logs:   line,
+	})
+	if o.stream == "stdout" {
+		*o.recv = append(*o.recv, line)
+	}
+	return len(p), nil
+}
+
+// runChecked is SendChecked for transports that run commands themselves,
+// the timeouts work the same way
+func (s *Session) runChecked(sessionCtx context.Context, t CommandTransport, commands ...string) (int, []string, error) {
+	e, err := EmitterFromContext(sessionCtx)
+	if err != nil {
+		return -1, []string{}, err
+	}
+
+	command := strings.Join(commands, "\n") + "\n"
+	e.Emit(Logs, &LogsArgs{
+		Hidden: s.logsHidden,
+		Stream: "stdin",
+		Logs:   command,
+	})
+
+	recv := []string{}
+	lock := &sync.Mutex{}
+	activity := make(chan struct{}, 1)
+	stdout := &commandOutput{session: s, emitter: e, stream: "stdout", activity: activity, lock: lock, recv: &recv}
+	stderr := &commandOutput{session: s, emitter: e, stream: "stderr", activity: activity, lock: lock, recv: &recv}
+	result := func(exit int, err error) (int, []string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		stdout.done = true
+		stderr.done = true
+		return exit, recv, err
+	}
+
+	sendCtx, cancel := context.WithTimeout(sessionCtx, time.Duration(s.options.CommandTimeout)*time.Millisecond)
+	defer cancel()
+
+	completed := make(chan commandResult, 1)
+	s.enqueue(t, &queuedCommand{
+		ctx:     sendCtx,
+		command: command,
+		stdout:  stdout,
+		stderr:  stderr,
+		done:    completed,
+	})
+
+	noResponse := time.Duration(s.options.NoResponseTimeout) * time.Millisecond
+	for {
+		select {
+		case r := <-completed:
+			if r.err == nil && r.exit != 0 {
+				r.err = fmt.Errorf("Command exited with exit code: %d", r.exit)
+			}
+			if r.err == context.DeadlineExceeded {
+				r.err = fmt.Errorf("Command timed out")
+			} else if r.err == context.Canceled {
+				r.err = fmt.Errorf("Command cancelled due to error")
+			}
+			return result(r.exit, r.err)
+		case <-activity:
+			continue
+		case <-time.After(noResponse):
+			return result(-1, fmt.Errorf("Command timed out after no response"))
+		case <-sendCtx.Done():
+			err := fmt.Errorf("Command timed out")
+			if sendCtx.Err() == context.Canceled {
+				err = fmt.Errorf("Command cancelled due to error")
+			}
+			return result(-1, err)
+		}
+	}
+}
+
+// enqueue runs c on t once the commands sent before it are done
+func (s *Session) enqueue(t CommandTransport, c *queuedCommand) {
+	s.queueOnce.Do(func() {
+		s.queue = make(chan *queuedCommand, 100)
+		go s.runQueue(t)
+	})
+	s.queue <- c
+}
+
+func (s *Session) runQueue(t CommandTransport) {
+	for c := range s.queue {
+		exit, err := t.RunCommand(c.ctx, c.command, c.stdout, c.stderr)
+		if c.done != nil {
+			c.done <- commandResult{exit: exit, err: err}
+		} else if err != nil {
+			s.logger.Errorln("Error running command:", err)
+		}
+	}
+}
@@ -18,7 +18,9 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 	"github.com/wercker/wercker/util"
@@ -178,6 +180,37 @@ func (s *SessionSuite) TestSendChecked() {
 	s.Equal("bar\n", recv[0])
 }
 
+func (s *SessionSuite) TestSendCheckedStderr() {
+	sessionCtx, _, session, transport := FakeSession(s.TestSuite, nil)
+	randomSentinel = fakeSentinel("test-sentinel")
+
+	e, err := EmitterFromContext(sessionCtx)
+	s.Require().NoError(err)
+	stderr := []string{}
+	e.AddListener(Logs, func(args *LogsArgs) {
+		if args.Stream == "stderr" {
+			stderr = append(stderr, args.Logs)
+		}
+	})
+
+	go func() {
+		for {
+			if strings.HasPrefix(<-transport.inchan, "echo test-sentinel") {
+				transport.stderr.Write([]byte("oops\n"))
+				transport.outchan <- "foo\n"
+				transport.outchan <- "test-sentinel 0\n"
+				return
+			}
+		}
+	}()
+
+	exit, recv, err := session.SendChecked(sessionCtx, "foo")
+	s.NoError(err)
+	s.Equal(0, exit)
+	s.Equal([]string{"foo\n"}, recv, "stderr is not part of the output")
+	s.Equal([]string{"oops\n"}, stderr)
+}
+
 func (s *SessionSuite) TestSendCheckedCommandTimeout() {
 	opts := fakeSessionOptions()
 	opts.CommandTimeout = 0
@@ -242,6 +275,121 @@ func (s *SessionSuite) TestSendCheckedEarlyExit() {
 
 }
 
+// FakeCommandTransport runs commands by calling run
+type FakeCommandTransport struct {
+	run func(context.Context, string, io.Writer, io.Writer) (int, error)
+}
+
+func (t *FakeCommandTransport) Attach(sessionCtx context.Context, stdin io.Reader, stdout, stderr io.Writer) (context.Context, error) {
+	return sessionCtx, nil
+}
+
+func (t *FakeCommandTransport) RunCommand(ctx context.Context, command string, stdout, stderr io.Writer) (int, error) {
+	return t.run(ctx, command, stdout, stderr)
+}
+
+func (s *SessionSuite) fakeCommandSession(run func(context.Context, string, io.Writer, io.Writer) (int, error)) (context.Context, *Session) {
+	ctx := NewEmitterContext(context.Background())
+	session := NewSession(fakeSessionOptions(), &FakeCommandTransport{run: run})
+	sessionCtx, err := session.Attach(ctx)
+	s.Require().NoError(err)
+	return sessionCtx, session
+}
+
+func (s *SessionSuite) TestSendCheckedCommandTransport() {
+	sessionCtx, session := s.fakeCommandSession(func(ctx context.Context, command string, stdout, stderr io.Writer) (int, error) {
+		s.Equal("foo\nbar\n", command)
+		io.WriteString(stdout, "out")
+		io.WriteString(stderr, "err")
+		if strings.HasPrefix(command, "foo") {
+			return 0, nil
+		}
+		return 2, nil
+	})
+
+	exit, recv, err := session.SendChecked(sessionCtx, "foo", "bar")
+	s.NoError(err)
+	s.Equal(0, exit)
+	s.Equal([]string{"out"}, recv, "stderr is not part of the output")
+}
+
+func (s *SessionSuite) TestSendCheckedCommandTransportExitCode() {
+	sessionCtx, session := s.fakeCommandSession(func(ctx context.Context, command string, stdout, stderr io.Writer) (int, error) {
+		return 2, nil
+	})
+
+	exit, _, err := session.SendChecked(sessionCtx, "false")
+	s.Error(err)
+	s.Equal(2, exit)
+}
+
+func (s *SessionSuite) TestSendCheckedCommandTransportNoResponse() {
+	sessionCtx, session := s.fakeCommandSession(func(ctx context.Context, command string, stdout, stderr io.Writer) (int, error) {
+		<-ctx.Done()
+		return -1, ctx.Err()
+	})
+
+	exit, recv, err := session.SendChecked(sessionCtx, "sleep 600")
+	s.Error(err)
+	s.Equal(-1, exit)
+	s.Empty(recv)
+}
+
+func (s *SessionSuite) TestCommandTransportRunsInOrder() {
+	lock := &sync.Mutex{}
+	running := 0
+	ran := []string{}
+	sessionCtx, session := s.fakeCommandSession(func(ctx context.Context, command string, stdout, stderr io.Writer) (int, error) {
+		lock.Lock()
+		running++
+		s.Equal(1, running, "commands should never overlap")
+		lock.Unlock()
+		if strings.HasPrefix(command, "slow") {
+			time.Sleep(50 * time.Millisecond)
+		}
+		lock.Lock()
+		running--
+		ran = append(ran, command)
+		lock.Unlock()
+		return 0, nil
+	})
+
+	s.Require().NoError(session.Send(sessionCtx, false, "slow"))
+	_, _, err := session.SendChecked(sessionCtx, "fast")
+	s.Require().NoError(err)
+	s.Equal([]string{"slow\n", "fast\n"}, ran)
+}
+
+func (s *SessionSuite) TestCommandTransportTimeoutThenNext() {
+	lock := &sync.Mutex{}
+	running := 0
+	ran := []string{}
+	sessionCtx, session := s.fakeCommandSession(func(ctx context.Context, command string, stdout, stderr io.Writer) (int, error) {
+		lock.Lock()
+		running++
+		s.Equal(1, running, "commands should never overlap")
+		lock.Unlock()
+		var err error
+		if strings.HasPrefix(command, "hang") {
+			// Stopping the command takes a while
+			<-ctx.Done()
+			time.Sleep(50 * time.Millisecond)
+			err = ctx.Err()
+		}
+		lock.Lock()
+		running--
+		ran = append(ran, command)
+		lock.Unlock()
+		return 0, err
+	})
+
+	_, _, err := session.SendChecked(sessionCtx, "hang")
+	s.Error(err)
+	_, _, err = session.SendChecked(sessionCtx, "next")
+	s.Require().NoError(err)
+	s.Equal([]string{"hang\n", "next\n"}, ran)
+}
+
 func (s *SessionSuite) TestSmartSplitLines() {
 	sentinel := "FOO9000"
 	sentinelLine := "FOO9000 1\n"
@@ -89,6 +89,8 @@ type Step interface {
 	SafeID() string
 	Version() string
 	ShouldSyncEnv() bool
+	Debug() bool
+	Data() map[string]string
 
 	// Actual methods
 	Fetch() (string, error)
@@ -112,6 +114,8 @@ type BaseStepOptions struct {
 	SafeID      string
 	Version     string
 	Cwd         string
+	Debug       bool
+	Data        map[string]string
 }
 
 // BaseStep type for extending
@@ -124,6 +128,8 @@ type BaseStep struct {
 	safeID      string
 	version     string
 	cwd         string
+	debug       bool
+	data        map[string]string
 }
 
 func NewBaseStep(args BaseStepOptions) *BaseStep {
@@ -136,6 +142,8 @@ func NewBaseStep(args BaseStepOptions) *BaseStep {
 		safeID:      args.SafeID,
 		version:     args.Version,
 		cwd:         args.Cwd,
+		debug:       args.Debug,
+		data:        args.Data,
 	}
 }
 
@@ -179,11 +187,20 @@ func (s *BaseStep) Version() string {
 	return s.version
 }
 
+// Debug getter
+func (s *BaseStep) Debug() bool {
+	return s.debug
+}
+
+// Data getter
+func (s *BaseStep) Data() map[string]string {
+	return s.data
+}
+
 // ExternalStep is the holder of the Step methods.
 type ExternalStep struct {
 	*BaseStep
 	url      string
-	data     map[string]string
 	stepDesc *StepDesc
 	logger   *util.LogEntry
 	options  *PipelineOptions
@@ -262,9 +279,10 @@ func NewStep(stepConfig *StepConfig, options *PipelineOptions) (*ExternalStep, e
 			safeID:      stepSafeID,
 			version:     version,
 			cwd:         stepConfig.Cwd,
+			debug:       stepConfig.Debug,
+			data:        data,
 		},
 		options: options,
-		data:    data,
 		url:     url,
 		logger:  logger,
 	}, nil
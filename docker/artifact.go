@@ -51,7 +51,10 @@ func NewArtificer(options *core.PipelineOptions, dockerOptions *DockerOptions) *
 // Collect an artifact from the container, if it doesn't have any files in
 // the tarball return util.ErrEmptyTarball
 func (a *Artificer) Collect(artifact *core.Artifact) (*core.Artifact, error) {
-	client, _ := NewDockerClient(a.dockerOptions)
+	client, err := NewContainerRuntime(a.dockerOptions)
+	if err != nil {
+		return nil, err
+	}
 
 	if err := os.MkdirAll(filepath.Dir(artifact.HostPath), 0755); err != nil {
 		return nil, err
@@ -68,7 +71,7 @@ func (a *Artificer) Collect(artifact *core.Artifact) (*core.Artifact, error) {
 		Container:    artifact.ContainerID,
 		Resource:     artifact.GuestPath,
 	}
-	if err = client.CopyFromContainer(opts); err != nil {
+	if err = copyFromContainer(client, opts); err != nil {
 		return nil, err
 	}
 
@@ -111,13 +114,13 @@ func (a *Artificer) Upload(artifact *core.Artifact) error {
 
 // DockerFileCollector impl of FileCollector
 type DockerFileCollector struct {
-	client      *DockerClient
+	client      ContainerRuntime
 	containerID string
 	logger      *util.LogEntry
 }
 
 // NewDockerFileCollector constructor
-func NewDockerFileCollector(client *DockerClient, containerID string) *DockerFileCollector {
+func NewDockerFileCollector(client ContainerRuntime, containerID string) *DockerFileCollector {
 	return &DockerFileCollector{
 		client:      client,
 		containerID: containerID,
@@ -139,13 +142,17 @@ func (fc *DockerFileCollector) Collect(path string) (*util.Archive, chan error)
 
 	go func() {
 		defer close(errs)
-		if err := fc.client.CopyFromContainer(opts); err != nil {
+		if err := copyFromContainer(fc.client, opts); err != nil {
 			switch err.(type) {
 			case *docker.Error:
 				derr := err.(*docker.Error)
 				if derr.Status == 500 && strings.HasPrefix(derr.Message, "Could not find the file") {
 					errs <- util.ErrEmptyTarball
 				}
+				// The archive endpoint (used by podman) says 404 instead
+				if derr.Status == 404 {
+					errs <- util.ErrEmptyTarball
+				}
 			default:
 				errs <- err
 			}
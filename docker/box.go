@@ -15,6 +15,7 @@
 package dockerlocal
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -37,7 +38,7 @@ type DockerBox struct {
 	Name            string
 	ShortName       string
 	networkDisabled bool
-	client          *DockerClient
+	client          ContainerRuntime
 	services        []core.ServiceBox
 	options         *core.PipelineOptions
 	dockerOptions   *DockerOptions
@@ -50,12 +51,23 @@ type DockerBox struct {
 	logger          *util.LogEntry
 	entrypoint      string
 	image           *docker.Image
+	oomKills        int
+	network         string
+	resumed         bool
 }
 
 // NewDockerBox from a name and other references
 func NewDockerBox(boxConfig *core.BoxConfig, options *core.PipelineOptions, dockerOptions *DockerOptions) (*DockerBox, error) {
 	name := boxConfig.ID
 
+	// Boxes built from a Dockerfile get their tag once they're built
+	if boxConfig.Dockerfile != "" {
+		if name != "" {
+			return nil, fmt.Errorf("A box needs either an id or a dockerfile, not both")
+		}
+		name = dockerfileRepository(options)
+	}
+
 	if strings.Contains(name, "@") {
 		return nil, fmt.Errorf("Invalid box name, '@' is not allowed in docker repositories.")
 	}
@@ -86,13 +98,22 @@ func NewDockerBox(boxConfig *core.BoxConfig, options *core.PipelineOptions, dock
 
 	entrypoint := boxConfig.Entrypoint
 
+	// Catch bad limits and mounts now rather than when the container is
+	// created
+	if err := resourceLimits(boxConfig, &docker.HostConfig{}); err != nil {
+		return nil, err
+	}
+	if err := boxMounts(boxConfig, options.AllowPrivileged, &docker.HostConfig{}); err != nil {
+		return nil, err
+	}
+
 	logger := util.RootLogger().WithFields(util.LogFields{
 		"Logger":    "Box",
 		"Name":      name,
 		"ShortName": shortName,
 	})
 
-	client, err := NewDockerClient(dockerOptions)
+	client, err := NewContainerRuntime(dockerOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -113,21 +134,6 @@ func NewDockerBox(boxConfig *core.BoxConfig, options *core.PipelineOptions, dock
 	}, nil
 }
 
-func (b *DockerBox) links() []string {
-	serviceLinks := []string{}
-
-	for _, service := range b.services {
-		serviceLinks = append(serviceLinks, service.Link())
-	}
-	b.logger.Debugln("Creating links:", serviceLinks)
-	return serviceLinks
-}
-
-// Link gives us the parameter to Docker to link to this box
-func (b *DockerBox) Link() string {
-	return fmt.Sprintf("%s:%s", b.container.Name, b.ShortName)
-}
-
 // GetName gets the box name
 func (b *DockerBox) GetName() string {
 	return b.Name
@@ -171,21 +177,54 @@ func (b *DockerBox) binds() ([]string, error) {
 	return binds, nil
 }
 
-// RunServices runs the services associated with this box
+// RunServices runs the services associated with this box on its network,
+// a service is only started once everything it depends on is ready
 func (b *DockerBox) RunServices(ctx context.Context, env *util.Environment) error {
-	links := []string{}
+	levels, err := serviceLevels(b.services)
+	if err != nil {
+		return err
+	}
 
-	for _, service := range b.services {
-		b.logger.Debugln("Startinq service:", service.GetName())
-		_, err := service.Run(ctx, env, links)
+	for _, level := range levels {
+		for _, service := range level {
+			b.logger.Debugln("Startinq service:", service.GetName())
+			_, err := service.Run(ctx, env, b.network)
+			if err != nil {
+				return err
+			}
+		}
+
+		errs := make(chan error, len(level))
+		for _, service := range level {
+			go func(service core.ServiceBox) {
+				errs <- service.WaitReady(ctx)
+			}(service)
+		}
+		for range level {
+			if serviceErr := <-errs; serviceErr != nil && err == nil {
+				err = serviceErr
+			}
+		}
 		if err != nil {
 			return err
 		}
-		links = append(links, service.Link())
 	}
 	return nil
 }
 
+// serviceEnv gives us the legacy link variables for all our services
+func (b *DockerBox) serviceEnv() ([]string, error) {
+	env := []string{}
+	for _, service := range b.services {
+		container, err := b.client.InspectContainer(service.GetID())
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, linkEnv(service.Aliases()[0], container, b.network)...)
+	}
+	return env, nil
+}
+
 func dockerEnv(boxEnv map[string]string, env *util.Environment) []string {
 	s := []string{}
 	for k, v := range boxEnv {
@@ -281,13 +320,22 @@ func exposedPortMaps(dockerHost string, published []string) ([]ExposedPortMap, e
 
 //RecoverInteractive restarts the box with a terminal attached
 func (b *DockerBox) RecoverInteractive(cwd string, pipeline core.Pipeline, step core.Step) error {
-	// TODO(termie): maybe move the container manipulation outside of here?
-	client := b.client
-	container, err := b.Restart()
+	_, err := b.Restart()
 	if err != nil {
 		b.logger.Panicln("box restart failed")
 		return err
 	}
+	return b.AttachInteractive(cwd, pipeline, step)
+}
+
+// AttachInteractive gives us a terminal in the running box with the
+// environment of step
+func (b *DockerBox) AttachInteractive(cwd string, pipeline core.Pipeline, step core.Step) error {
+	if b.container == nil {
+		return fmt.Errorf("Box is not running: %s", b.Name)
+	}
+	// TODO(termie): maybe move the container manipulation outside of here?
+	client := b.client
 
 	env := []string{}
 	env = append(env, pipeline.Env().Export()...)
@@ -296,7 +344,7 @@ func (b *DockerBox) RecoverInteractive(cwd string, pipeline core.Pipeline, step
 	env = append(env, fmt.Sprintf("cd %s", cwd))
 	env = append(env, fmt.Sprintf("clear"))
 	cmd := []string{b.cmd}
-	return client.AttachInteractive(container.ID, cmd, env)
+	return client.AttachInteractive(b.container.ID, cmd, nil, env)
 }
 
 func (b *DockerBox) getContainerName() string {
@@ -305,7 +353,12 @@ func (b *DockerBox) getContainerName() string {
 
 // Run creates the container and runs it.
 func (b *DockerBox) Run(ctx context.Context, env *util.Environment) (*docker.Container, error) {
-	err := b.RunServices(ctx, env)
+	err := b.createNetwork()
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.RunServices(ctx, env)
 	if err != nil {
 		return nil, err
 	}
@@ -316,6 +369,11 @@ func (b *DockerBox) Run(ctx context.Context, env *util.Environment) (*docker.Con
 
 	// Import the environment
 	myEnv := dockerEnv(b.config.Env, env)
+	linkEnv, err := b.serviceEnv()
+	if err != nil {
+		return nil, err
+	}
+	myEnv = append(linkEnv, myEnv...)
 
 	var entrypoint []string
 	if b.entrypoint != "" {
@@ -330,7 +388,28 @@ func (b *DockerBox) Run(ctx context.Context, env *util.Environment) (*docker.Con
 		return nil, err
 	}
 
-	// Make and start the container
+	binds, err := b.binds()
+	if err != nil {
+		return nil, err
+	}
+
+	hostConfig := &docker.HostConfig{
+		Binds:        binds,
+		PortBindings: portBindings(b.options.PublishPorts),
+		DNS:          b.dockerOptions.DockerDNS,
+		NetworkMode:  b.network,
+	}
+	err = resourceLimits(b.config, hostConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = boxMounts(b.config, b.options.AllowPrivileged, hostConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make and start the container, the HostConfig goes in at create time
+	// since not every runtime accepts it on start
 	container, err := client.CreateContainer(
 		docker.CreateContainerOptions{
 			Name: b.getContainerName(),
@@ -347,8 +426,11 @@ func (b *DockerBox) Run(ctx context.Context, env *util.Environment) (*docker.Con
 				NetworkDisabled: b.networkDisabled,
 				DNS:             b.dockerOptions.DockerDNS,
 				Entrypoint:      entrypoint,
+				Labels:          pipelineLabels(b.options),
 				// Volumes: volumes,
 			},
+			HostConfig:       hostConfig,
+			NetworkingConfig: networkingConfig(b.network, []string{b.ShortName}),
 		})
 	if err != nil {
 		return nil, err
@@ -356,17 +438,7 @@ func (b *DockerBox) Run(ctx context.Context, env *util.Environment) (*docker.Con
 
 	b.logger.Debugln("Docker Container:", container.ID)
 
-	binds, err := b.binds()
-	if err != nil {
-		return nil, err
-	}
-
-	client.StartContainer(container.ID, &docker.HostConfig{
-		Binds:        binds,
-		Links:        b.links(),
-		PortBindings: portBindings(b.options.PublishPorts),
-		DNS:          b.dockerOptions.DockerDNS,
-	})
+	client.StartContainer(container.ID, nil)
 	b.container = container
 	return container, nil
 }
@@ -378,9 +450,12 @@ func (b *DockerBox) Clean() error {
 		containers = append(containers, b.container.ID)
 	}
 
-	for _, service := range b.services {
-		if containerID := service.GetID(); containerID != "" {
-			containers = append(containers, containerID)
+	// Kept services stay around for the next run
+	if !b.options.KeepServices {
+		for _, service := range b.services {
+			if containerID := service.GetID(); containerID != "" {
+				containers = append(containers, containerID)
+			}
 		}
 	}
 
@@ -403,6 +478,11 @@ func (b *DockerBox) Clean() error {
 		}
 	}
 
+	err := b.removeNetwork()
+	if err != nil {
+		return err
+	}
+
 	if !b.options.ShouldCommit {
 		for i := len(b.images) - 1; i >= 0; i-- {
 			b.logger.WithField("Image", b.images[i].ID).Debugln("Removing image:", b.images[i].ID)
@@ -424,24 +504,59 @@ func (b *DockerBox) Restart() (*docker.Container, error) {
 	return b.container, nil
 }
 
+// OOMKilled tells us whether anything in the box was killed for running out
+// of memory since the last time we asked
+func (b *DockerBox) OOMKilled() bool {
+	if b.container == nil {
+		return false
+	}
+	container, err := b.client.InspectContainer(b.container.ID)
+	if err == nil && container.State.OOMKilled {
+		return true
+	}
+
+	// The container survives if the kernel only killed a step's process, in
+	// that case the cgroup counters are the only trace
+	if b.config.Memory == "" {
+		return false
+	}
+	var out bytes.Buffer
+	err = b.client.ExecOne(b.container.ID, oomCheckCmd, &out)
+	if err != nil {
+		b.logger.WithField("Error", err).Debugln("Unable to read OOM counters")
+		return false
+	}
+	count := oomKillCount(out.String())
+	killed := count > b.oomKills
+	b.oomKills = count
+	return killed
+}
+
 // AddService needed by this Box
 func (b *DockerBox) AddService(service core.ServiceBox) {
 	b.services = append(b.services, service)
 }
 
-// Stop the box and all its services
+// Services that have been added to this box
+func (b *DockerBox) Services() []core.ServiceBox {
+	return b.services
+}
+
+// Stop the box and all its services, unless they are kept
 func (b *DockerBox) Stop() {
 	// TODO(termie): maybe move the container manipulation outside of here?
 	client := b.client
-	for _, service := range b.services {
-		b.logger.Debugln("Stopping service", service.GetID())
-		err := client.StopContainer(service.GetID(), 1)
-
-		if err != nil {
-			if _, ok := err.(*docker.ContainerNotRunning); ok {
-				b.logger.Warnln("Service container has already stopped.")
-			} else {
-				b.logger.WithField("Error", err).Warnln("Wasn't able to stop service container", service.GetID())
+	if !b.options.KeepServices {
+		for _, service := range b.services {
+			b.logger.Debugln("Stopping service", service.GetID())
+			err := client.StopContainer(service.GetID(), 1)
+
+			if err != nil {
+				if _, ok := err.(*docker.ContainerNotRunning); ok {
+					b.logger.Warnln("Service container has already stopped.")
+				} else {
+					b.logger.WithField("Error", err).Warnln("Wasn't able to stop service container", service.GetID())
+				}
 			}
 		}
 	}
@@ -469,6 +584,15 @@ func (b *DockerBox) Fetch(ctx context.Context, env *util.Environment) (*docker.I
 		return nil, err
 	}
 
+	// Checkpoints only exist locally
+	if b.resumed {
+		return b.image, nil
+	}
+
+	if b.config.Dockerfile != "" {
+		return b.fetchDockerfile(ctx, env)
+	}
+
 	// Shortcut to speed up local dev
 	if b.dockerOptions.DockerLocal {
 		image, err := client.InspectImage(env.Interpolate(b.Name))
@@ -492,7 +616,7 @@ func (b *DockerBox) Fetch(ctx context.Context, env *util.Environment) (*docker.I
 		Registry:   env.Interpolate(b.config.Registry),
 	}
 
-	check, err := client.CheckAccess(checkOpts)
+	check, err := checkAccess(client, checkOpts)
 	if err != nil {
 		b.logger.Errorln("Error during check access")
 		return nil, err
@@ -534,7 +658,7 @@ func (b *DockerBox) Fetch(ctx context.Context, env *util.Environment) (*docker.I
 }
 
 // Commit the current running Docker container to an Docker image.
-func (b *DockerBox) Commit(name, tag, message string) (*docker.Image, error) {
+func (b *DockerBox) Commit(ctx context.Context, name, tag, message string) (*docker.Image, error) {
 	b.logger.WithFields(util.LogFields{
 		"Name": name,
 		"Tag":  tag,
@@ -555,6 +679,22 @@ func (b *DockerBox) Commit(name, tag, message string) (*docker.Image, error) {
 		return nil, err
 	}
 
+	if b.image != nil {
+		squashed, report, err := commitReport(client, b.options.BuildPath(), b.image.ID, image, fmt.Sprintf("%s:%s", name, tag), message, b.options.ShouldSquash)
+		if err != nil && b.options.ShouldSquash {
+			return nil, err
+		} else if err != nil {
+			b.logger.WithField("Error", err).Warnln("Unable to report on image size")
+		} else {
+			image = squashed
+			if e, err := core.EmitterFromContext(ctx); err == nil {
+				e.Emit(core.Logs, &core.LogsArgs{
+					Logs: report.String(),
+				})
+			}
+		}
+	}
+
 	b.images = append(b.images, image)
 
 	return image, nil
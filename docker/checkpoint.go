@@ -0,0 +1,201 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+)
+
+const (
+	// checkpointKeyLabel records the cache key of the step a checkpoint was
+	// taken after, so we notice when anything before it has changed since
+	checkpointKeyLabel = "wercker.checkpoint-key"
+
+	// checkpointPipelineLabel and checkpointRunLabel record which pipeline
+	// and which run of it took a checkpoint, for pruning cached steps
+	checkpointPipelineLabel = "wercker.checkpoint-pipeline"
+	checkpointRunLabel      = "wercker.checkpoint-run"
+)
+
+// cacheTag matches the tags of cached steps, which are their cache keys
+var cacheTag = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// checkpointRepository holds the checkpoints of the project, both the ones
+// taken for reruns and the cached steps
+func checkpointRepository(options *core.PipelineOptions) string {
+	project := strings.ToLower(keptServicesProject(options))
+	if project == "" {
+		project = "unknown"
+	}
+	return "wercker-checkpoints/" + project
+}
+
+// ImageID is the ID of the image the box starts from
+func (b *DockerBox) ImageID() string {
+	if b.image == nil {
+		return ""
+	}
+	return b.image.ID
+}
+
+// BoxEnv is the environment from the box config that containers of the
+// box start with, sorted
+func (b *DockerBox) BoxEnv(env *util.Environment) []string {
+	boxEnv := dockerEnv(b.config.Env, env)
+	sort.Strings(boxEnv)
+	return boxEnv
+}
+
+// Checkpoint commits the container after step, which has the cache key
+// key, once and tags it with every one of tags
+func (b *DockerBox) Checkpoint(key string, step core.Step, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	repository := checkpointRepository(b.options)
+	b.logger.Debugln("Checkpoint:", repository, tags)
+	image, err := b.client.CommitContainer(docker.CommitContainerOptions{
+		Container:  b.container.ID,
+		Repository: repository,
+		Tag:        containerSafe(tags[0]),
+		Message:    fmt.Sprintf("Checkpoint after %s", step.DisplayName()),
+		Author:     "wercker",
+		Run: &docker.Config{
+			Labels: map[string]string{
+				checkpointKeyLabel:      key,
+				checkpointPipelineLabel: b.options.Pipeline,
+				checkpointRunLabel:      b.options.PipelineID,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags[1:] {
+		err := b.client.TagImage(image.ID, docker.TagImageOptions{
+			Repo:  repository,
+			Tag:   containerSafe(tag),
+			Force: true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resume makes the box start from the checkpoint under tag instead of from
+// its own image, it returns false if there is none or it was taken after a
+// step with a different cache key
+func (b *DockerBox) Resume(tag, key string) (bool, error) {
+	repository := checkpointRepository(b.options)
+	tag = containerSafe(tag)
+	name := fmt.Sprintf("%s:%s", repository, tag)
+	image, err := b.client.InspectImage(name)
+	if err == docker.ErrNoSuchImage {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if image.Config == nil || image.Config.Labels[checkpointKeyLabel] != key {
+		return false, nil
+	}
+
+	b.logger.Debugln("Resuming from checkpoint:", name)
+	b.Name = name
+	b.repository = repository
+	b.tag = tag
+	b.image = image
+	b.resumed = true
+	return true, nil
+}
+
+// Resumed is whether the box starts from a checkpoint
+func (b *DockerBox) Resumed() bool {
+	return b.resumed
+}
+
+// byLatest sorts runs newest first by when they last took a checkpoint
+type byLatest struct {
+	runs   []string
+	latest map[string]int64
+}
+
+func (s byLatest) Len() int      { return len(s.runs) }
+func (s byLatest) Swap(i, j int) { s.runs[i], s.runs[j] = s.runs[j], s.runs[i] }
+func (s byLatest) Less(i, j int) bool {
+	return s.latest[s.runs[i]] > s.latest[s.runs[j]]
+}
+
+// PruneCache removes the cached steps of the pipeline that aren't one of
+// keys, except for the ones taken by the latest runs other than this one
+func (b *DockerBox) PruneCache(keys []string, runs int) error {
+	repository := checkpointRepository(b.options)
+	images, err := b.client.ListImages(docker.ListImagesOptions{
+		Filters: map[string][]string{
+			"label": {fmt.Sprintf("%s=%s", checkpointPipelineLabel, b.options.Pipeline)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Newest image of every other run, to work out which runs to keep
+	latest := map[string]int64{}
+	for _, image := range images {
+		run := image.Labels[checkpointRunLabel]
+		if run != b.options.PipelineID && image.Created > latest[run] {
+			latest[run] = image.Created
+		}
+	}
+	others := []string{}
+	for run := range latest {
+		others = append(others, run)
+	}
+	sort.Sort(byLatest{others, latest})
+	keep := map[string]bool{}
+	for i := 0; i < len(others) && i < runs-1; i++ {
+		keep[others[i]] = true
+	}
+	current := map[string]bool{}
+	for _, key := range keys {
+		current[containerSafe(key)] = true
+	}
+
+	for _, image := range images {
+		if keep[image.Labels[checkpointRunLabel]] {
+			continue
+		}
+		for _, repoTag := range image.RepoTags {
+			repo, tag := docker.ParseRepositoryTag(repoTag)
+			if repo != repository || !cacheTag.MatchString(tag) || current[tag] {
+				continue
+			}
+			b.logger.Debugln("Pruning cached step:", repoTag)
+			if err := b.client.RemoveImage(repoTag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/internal/fakeruntime/sandbox"
+	"github.com/wercker/wercker/util"
+)
+
+type CheckpointSuite struct {
+	*util.TestSuite
+}
+
+func TestCheckpointSuite(t *testing.T) {
+	suiteTester := &CheckpointSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *CheckpointSuite) TestCheckpointAndPrune() {
+	fake, err := sandbox.New(filepath.Join(s.WorkingDir(), "sandbox"))
+	s.Require().NoError(err)
+	defer fake.Close()
+	dockerOptions := &DockerOptions{Client: fake, DockerLocal: true}
+
+	options := core.EmptyPipelineOptions()
+	options.ApplicationName = "My App"
+	options.Pipeline = "build"
+	box, err := NewDockerBox(&core.BoxConfig{ID: "ubuntu"}, options, dockerOptions)
+	s.Require().NoError(err)
+	box.container, err = fake.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{Image: "ubuntu"}})
+	s.Require().NoError(err)
+	step, err := core.NewStep(&core.StepConfig{ID: "script"}, options)
+	s.Require().NoError(err)
+	repository := checkpointRepository(options)
+
+	// One commit per step, tagged for reruns and for the cache
+	keys := []string{strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)}
+	for i, key := range keys {
+		options.PipelineID = []string{"run-1", "run-2", "run-3"}[i]
+		tags := []string{key}
+		if i == 0 {
+			tags = []string{"build-0", key}
+		}
+		s.Require().NoError(box.Checkpoint(key, step, tags...))
+		image, err := fake.InspectImage(repository + ":" + key)
+		s.Require().NoError(err)
+		image.Created = time.Unix(int64(i+1), 0)
+	}
+	images, err := fake.ListImages(docker.ListImagesOptions{})
+	s.Require().NoError(err)
+	s.Len(images, 3)
+
+	// Checkpoints only count after a step with the same key
+	found, err := box.Resume("build-0", keys[1])
+	s.Require().NoError(err)
+	s.False(found)
+	found, err = box.Resume("build-0", keys[0])
+	s.Require().NoError(err)
+	s.True(found)
+
+	// Keeping two runs keeps this one and the one before
+	s.Require().NoError(box.PruneCache(keys[2:], 2))
+	images, err = fake.ListImages(docker.ListImagesOptions{})
+	s.Require().NoError(err)
+	tags := []string{}
+	for _, image := range images {
+		tags = append(tags, image.RepoTags...)
+	}
+	sort.Strings(tags)
+	s.Equal([]string{repository + ":" + keys[1], repository + ":build-0", repository + ":" + keys[2]}, tags)
+}
@@ -0,0 +1,116 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/wercker/wercker/core"
+)
+
+// serviceLabel is the most specific name we have for a service
+func serviceLabel(service core.ServiceBox) string {
+	aliases := service.Aliases()
+	if len(aliases) == 0 {
+		return service.GetName()
+	}
+	return aliases[len(aliases)-1]
+}
+
+// serviceLevels sorts services by their depends-on so that every service
+// only depends on services in earlier levels. Within a level services keep
+// the order they were configured in.
+func serviceLevels(services []core.ServiceBox) ([][]core.ServiceBox, error) {
+	byAlias := map[string][]int{}
+	for i, service := range services {
+		for _, alias := range service.Aliases() {
+			byAlias[alias] = append(byAlias[alias], i)
+		}
+	}
+
+	deps := make([][]int, len(services))
+	for i, service := range services {
+		for _, name := range service.DependsOn() {
+			matches := byAlias[name]
+			switch {
+			case len(matches) == 0:
+				return nil, fmt.Errorf("Service %s depends on unknown service %s", serviceLabel(service), name)
+			case len(matches) > 1:
+				return nil, fmt.Errorf("Service %s depends on %s, which matches more than one service", serviceLabel(service), name)
+			}
+			deps[i] = append(deps[i], matches[0])
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(services))
+	level := make([]int, len(services))
+	stack := []int{}
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			return dependencyCycleError(services, stack, i)
+		}
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, dep := range deps[i] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+			if level[dep]+1 > level[i] {
+				level[i] = level[dep] + 1
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+		return nil
+	}
+
+	levels := [][]core.ServiceBox{}
+	for i := range services {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+		for len(levels) <= level[i] {
+			levels = append(levels, []core.ServiceBox{})
+		}
+	}
+	for i, service := range services {
+		levels[level[i]] = append(levels[level[i]], service)
+	}
+	return levels, nil
+}
+
+// dependencyCycleError describes the cycle that ends at service i
+func dependencyCycleError(services []core.ServiceBox, stack []int, i int) error {
+	names := []string{}
+	for j := len(stack) - 1; j >= 0; j-- {
+		names = append([]string{serviceLabel(services[stack[j]])}, names...)
+		if stack[j] == i {
+			break
+		}
+	}
+	names = append(names, serviceLabel(services[i]))
+	return fmt.Errorf("Services have a dependency cycle: %s", strings.Join(names, " -> "))
+}
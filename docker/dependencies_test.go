@@ -0,0 +1,120 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+	"golang.org/x/net/context"
+)
+
+type stubService struct {
+	name      string
+	dependsOn []string
+}
+
+func (s *stubService) Run(context.Context, *util.Environment, string) (*docker.Container, error) {
+	return nil, nil
+}
+func (s *stubService) Fetch(context.Context, *util.Environment) (*docker.Image, error) {
+	return nil, nil
+}
+func (s *stubService) Aliases() []string               { return []string{s.name} }
+func (s *stubService) DependsOn() []string             { return s.dependsOn }
+func (s *stubService) WaitReady(context.Context) error { return nil }
+func (s *stubService) LogPath() string                 { return "" }
+func (s *stubService) LogsDone() <-chan struct{}       { return nil }
+func (s *stubService) GetID() string                   { return s.name }
+func (s *stubService) GetName() string                 { return s.name }
+
+func names(levels [][]core.ServiceBox) [][]string {
+	out := [][]string{}
+	for _, level := range levels {
+		l := []string{}
+		for _, service := range level {
+			l = append(l, serviceLabel(service))
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+type DependenciesSuite struct {
+	*util.TestSuite
+}
+
+func TestDependenciesSuite(t *testing.T) {
+	suiteTester := &DependenciesSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *DependenciesSuite) TestServiceLevels() {
+	services := []core.ServiceBox{
+		&stubService{name: "app", dependsOn: []string{"postgres", "migrate"}},
+		&stubService{name: "migrate", dependsOn: []string{"postgres"}},
+		&stubService{name: "redis"},
+		&stubService{name: "postgres"},
+	}
+	levels, err := serviceLevels(services)
+	s.Require().Nil(err)
+	s.Equal([][]string{
+		{"redis", "postgres"},
+		{"migrate"},
+		{"app"},
+	}, names(levels))
+}
+
+func (s *DependenciesSuite) TestServiceLevelsNoDependencies() {
+	services := []core.ServiceBox{
+		&stubService{name: "mongo"},
+		&stubService{name: "redis"},
+	}
+	levels, err := serviceLevels(services)
+	s.Require().Nil(err)
+	s.Equal([][]string{{"mongo", "redis"}}, names(levels))
+
+	levels, err = serviceLevels(nil)
+	s.Require().Nil(err)
+	s.Empty(levels)
+}
+
+func (s *DependenciesSuite) TestServiceLevelsUnknown() {
+	services := []core.ServiceBox{
+		&stubService{name: "app", dependsOn: []string{"mysql"}},
+	}
+	_, err := serviceLevels(services)
+	s.EqualError(err, "Service app depends on unknown service mysql")
+}
+
+func (s *DependenciesSuite) TestServiceLevelsCycle() {
+	services := []core.ServiceBox{
+		&stubService{name: "redis"},
+		&stubService{name: "a", dependsOn: []string{"b"}},
+		&stubService{name: "b", dependsOn: []string{"c"}},
+		&stubService{name: "c", dependsOn: []string{"a", "redis"}},
+	}
+	_, err := serviceLevels(services)
+	s.EqualError(err, "Services have a dependency cycle: a -> b -> c -> a")
+
+	services = []core.ServiceBox{
+		&stubService{name: "self", dependsOn: []string{"self"}},
+	}
+	_, err = serviceLevels(services)
+	s.EqualError(err, "Services have a dependency cycle: self -> self")
+}
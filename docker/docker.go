@@ -18,7 +18,6 @@ import (
 	"archive/tar"
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"io"
 	"net/url"
@@ -50,7 +49,7 @@ type CheckAccessOptions struct {
 }
 
 func RequireDockerEndpoint(options *DockerOptions) error {
-	client, err := NewDockerClient(options)
+	client, err := NewContainerRuntime(options)
 	if err != nil {
 		if err == docker.ErrInvalidEndpoint {
 			return fmt.Errorf(`The given Docker endpoint is invalid:
@@ -150,8 +149,9 @@ func (c *DockerClient) RunAndAttach(name string) error {
 	return c.AttachTerminal(container.ID)
 }
 
-// AttachInteractive starts an interactive session and runs cmd
-func (c *DockerClient) AttachInteractive(containerID string, cmd []string, initialStdin []string) error {
+// AttachInteractive starts an interactive session and runs cmd with env
+// added to the container's environment
+func (c *DockerClient) AttachInteractive(containerID string, cmd []string, env []string, initialStdin []string) error {
 
 	exec, err := c.CreateExec(docker.CreateExecOptions{
 		AttachStdin:  true,
@@ -159,6 +159,7 @@ func (c *DockerClient) AttachInteractive(containerID string, cmd []string, initi
 		AttachStderr: true,
 		Tty:          true,
 		Cmd:          cmd,
+		Env:          env,
 		Container:    containerID,
 	})
 
@@ -388,27 +389,6 @@ type DockerScratchPushStep struct {
 	*DockerPushStep
 }
 
-// DockerImageJSON is a minimal JSON description for a docker layer
-type DockerImageJSON struct {
-	Architecture    string                         `json:"architecture"`
-	Created         time.Time                      `json:"created"`
-	Config          docker.Config                  `json:"config"`
-	Container       string                         `json:"container"`
-	ContainerConfig DockerImageJSONContainerConfig `json:"container_config"`
-	ID              string                         `json:"id"`
-	OS              string                         `json:"os"`
-	DockerVersion   string                         `json:"docker_version"`
-	Size            int64                          `json:"Size"`
-}
-
-// DockerImageJSONContainerConfig substructure
-type DockerImageJSONContainerConfig struct {
-	Hostname string
-	// Cmd      []string
-	// Memory int
-	// OpenStdin bool
-}
-
 // NewDockerScratchPushStep constructorama
 func NewDockerScratchPushStep(stepConfig *core.StepConfig, options *core.PipelineOptions, dockerOptions *DockerOptions) (*DockerScratchPushStep, error) {
 	name := "docker-scratch-push"
@@ -428,6 +408,8 @@ func NewDockerScratchPushStep(stepConfig *core.StepConfig, options *core.Pipelin
 		Owner:       "wercker",
 		SafeID:      stepSafeID,
 		Version:     util.Version(),
+		Debug:       stepConfig.Debug,
+		Data:        stepConfig.Data,
 	})
 
 	dockerPushStep := &DockerPushStep{
@@ -445,215 +427,98 @@ func NewDockerScratchPushStep(stepConfig *core.StepConfig, options *core.Pipelin
 func (s *DockerScratchPushStep) Execute(ctx context.Context, sess *core.Session) (int, error) {
 	// This is clearly only relevant to docker so we're going to dig into the
 	// transport internals a little bit to get the container ID
-	dt := sess.Transport().(*DockerTransport)
-	containerID := dt.containerID
+	containerID := sess.Transport().(containerTransport).ContainerID()
 
 	_, err := s.CollectArtifact(containerID)
 	if err != nil {
 		return -1, err
 	}
 
-	// At this point we've written the layer to disk, we're going to add up the
-	// sizes of all the files to add to our json format, and sha256 the data
-	layerFile, err := os.Open(s.options.HostPath("layer.tar"))
-	if err != nil {
-		return -1, err
-	}
-	defer layerFile.Close()
-
-	var layerSize int64
-
-	layerTar := tar.NewReader(layerFile)
-	for {
-		hdr, err := layerTar.Next()
-		if err == io.EOF {
-			// finished the tarball
-			break
-		}
-		if err != nil {
-			return -1, err
-		}
-
-		// Skip the base dir
-		if hdr.Name == "./" {
-			continue
-		}
-
-		layerSize += hdr.Size
-	}
-
-	config := docker.Config{
-		Cmd:          s.cmd,
-		Entrypoint:   s.entrypoint,
-		Hostname:     containerID[:16],
-		WorkingDir:   s.workingDir,
-		ExposedPorts: s.ports,
-		Volumes:      s.volumes,
-	}
-
-	layerID, err := GenerateDockerID()
-	if err != nil {
-		return -1, err
-	}
-
-	// Make the JSON file we need
-	imageJSON := DockerImageJSON{
-		Architecture: "amd64",
-		Container:    containerID,
-		ContainerConfig: DockerImageJSONContainerConfig{
-			Hostname: containerID[:16],
-		},
-		DockerVersion: "1.5",
-		Created:       time.Now(),
-		ID:            layerID,
-		OS:            "linux",
-		Size:          layerSize,
-		Config:        config,
-	}
-
-	jsonOut, err := json.MarshalIndent(imageJSON, "", "  ")
-	if err != nil {
-		return -1, err
-	}
-	s.logger.Debugln(jsonOut)
-
-	// Write out the files to disk that we are going to care about
-	err = os.MkdirAll(s.options.HostPath("scratch", layerID), 0755)
+	client, err := NewContainerRuntime(s.dockerOptions)
 	if err != nil {
-		return -1, err
+		return 1, err
 	}
-	defer os.RemoveAll(s.options.HostPath("scratch"))
 
-	// VERSION file
-	versionFile, err := os.OpenFile(s.options.HostPath("scratch", layerID, "VERSION"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return -1, err
-	}
-	defer versionFile.Close()
-	_, err = versionFile.Write([]byte("1.0"))
-	if err != nil {
-		return -1, err
-	}
-	err = versionFile.Sync()
+	// layer.tar has an extra folder in it so we have to strip it :/
+	layerFile, err := os.Open(s.options.HostPath("layer.tar"))
 	if err != nil {
 		return -1, err
 	}
+	defer os.Remove(s.options.HostPath("layer.tar"))
+	defer layerFile.Close()
 
-	// json file
-	jsonFile, err := os.OpenFile(s.options.HostPath("scratch", layerID, "json"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return -1, err
-	}
-	defer jsonFile.Close()
-	_, err = jsonFile.Write(jsonOut)
-	if err != nil {
-		return -1, err
-	}
-	err = jsonFile.Sync()
+	stripped, strippedWriter := io.Pipe()
+	go func() {
+		strippedWriter.CloseWithError(stripLayerPrefix(layerFile, strippedWriter))
+	}()
+	layer, err := writeLayer(stripped, s.options.HostPath("layer.tar.gz"))
+	stripped.Close()
 	if err != nil {
 		return -1, err
 	}
-
-	// repositories file
-	repositoriesFile, err := os.OpenFile(s.options.HostPath("scratch", "repositories"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return -1, err
+	defer os.Remove(layer.path)
+
+	platform := daemonPlatform(client)
+	if architecture, ok := s.data["architecture"]; ok {
+		platform = parsePlatform(architecture)
+	}
+
+	created := time.Now().UTC()
+	config := &imageConfig{
+		Created:      created,
+		Author:       s.author,
+		Architecture: platform.Architecture,
+		Variant:      platform.Variant,
+		OS:           platform.OS,
+		Config: imageRunConfig{
+			User:       s.user,
+			Env:        s.env,
+			Entrypoint: s.entrypoint,
+			Cmd:        s.cmd,
+			Volumes:    s.volumes,
+			WorkingDir: s.workingDir,
+			Labels:     s.labels,
+			StopSignal: s.stopSignal,
+		},
+		History: []imageHistory{{
+			Created:   created,
+			CreatedBy: "wercker internal/docker-scratch-push",
+			Comment:   s.message,
+		}},
 	}
-	defer repositoriesFile.Close()
-	_, err = repositoriesFile.Write([]byte(fmt.Sprintf(`{"%s":{`, s.repository)))
-	if err != nil {
-		return -1, err
+	if len(s.ports) > 0 {
+		config.Config.ExposedPorts = map[string]struct{}{}
+		for port := range s.ports {
+			config.Config.ExposedPorts[string(port)] = struct{}{}
+		}
 	}
 
 	if len(s.tags) == 0 {
 		s.tags = []string{"latest"}
 	}
-
-	for i, tag := range s.tags {
-		_, err = repositoriesFile.Write([]byte(fmt.Sprintf(`"%s":"%s"`, tag, layerID)))
-		if err != nil {
-			return -1, err
-		}
-		if i != len(s.tags)-1 {
-			_, err = repositoriesFile.Write([]byte{','})
-			if err != nil {
-				return -1, err
-			}
-		}
-	}
-
-	_, err = repositoriesFile.Write([]byte{'}', '}'})
-	err = repositoriesFile.Sync()
-	if err != nil {
-		return -1, err
-	}
-	// layer.tar has an extra folder in it so we have to strip it :/
-	tempLayerFile, err := os.Open(s.options.HostPath("layer.tar"))
-	if err != nil {
-		return -1, err
-	}
-	defer os.Remove(s.options.HostPath("layer.tar"))
-	defer tempLayerFile.Close()
-
-	realLayerFile, err := os.OpenFile(s.options.HostPath("scratch", layerID, "layer.tar"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return -1, err
-	}
-	defer realLayerFile.Close()
-
-	tr := tar.NewReader(tempLayerFile)
-	tw := tar.NewWriter(realLayerFile)
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			// finished the tarball
-			break
-		}
-		if err != nil {
-			return -1, err
-		}
-		// Skip the base dir
-		if hdr.Name == "./" {
-			continue
-		}
-		if strings.HasPrefix(hdr.Name, "output/") {
-			hdr.Name = hdr.Name[len("output/"):]
-		} else if strings.HasPrefix(hdr.Name, "source/") {
-			hdr.Name = hdr.Name[len("source/"):]
-		}
-		if len(hdr.Name) == 0 {
-			continue
-		}
-		tw.WriteHeader(hdr)
-		_, err = io.Copy(tw, tr)
-		if err != nil {
-			return -1, err
-		}
+	repoTags := []string{}
+	for _, tag := range s.tags {
+		repoTags = append(repoTags, fmt.Sprintf("%s:%s", s.repository, tag))
 	}
-	tw.Close()
 
 	// Build our output tarball and start writing to it
 	imageFile, err := os.Create(s.options.HostPath("scratch.tar"))
-	defer imageFile.Close()
 	if err != nil {
 		return -1, err
 	}
-	err = util.TarPath(imageFile, s.options.HostPath("scratch"))
+	defer imageFile.Close()
+	imageID, err := writeImageArchive(imageFile, config, layer, repoTags)
 	if err != nil {
 		return -1, err
 	}
 	imageFile.Close()
 
-	client, err := NewDockerClient(s.dockerOptions)
-	if err != nil {
-		return 1, err
-	}
-
 	s.logger.WithFields(util.LogFields{
 		"Registry":   s.registry,
 		"Repository": s.repository,
 		"Tags":       s.tags,
 		"Message":    s.message,
+		"Platform":   fmt.Sprintf("%s/%s", platform.OS, platform.Architecture),
 	}).Debug("Scratch push to registry")
 
 	// Check the auth
@@ -672,7 +537,7 @@ func (s *DockerScratchPushStep) Execute(ctx context.Context, sess *core.Session)
 			Registry:   s.registry,
 		}
 
-		check, err := client.CheckAccess(checkOpts)
+		check, err := checkAccess(client, checkOpts)
 		if err != nil {
 			s.logger.Errorln("Error during check access", err)
 			return -1, err
@@ -685,13 +550,55 @@ func (s *DockerScratchPushStep) Execute(ctx context.Context, sess *core.Session)
 
 	// Okay, we can access it, do a docker load to import the image then push it
 	loadFile, err := os.Open(s.options.HostPath("scratch.tar"))
+	if err != nil {
+		return -1, err
+	}
 	defer loadFile.Close()
 	err = client.LoadImage(docker.LoadImageOptions{InputStream: loadFile})
 	if err != nil {
 		return -1, err
 	}
 	e, err := core.EmitterFromContext(ctx)
-	return s.tagAndPush(layerID, e, client, auth)
+	if err != nil {
+		return -1, err
+	}
+	return s.tagAndPush(imageID, e, client, auth)
+}
+
+// stripLayerPrefix copies the artifact tarball to w without the output/ or
+// source/ directory everything in it lives under
+func stripLayerPrefix(r io.Reader, w io.Writer) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			// finished the tarball
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// Skip the base dir
+		if hdr.Name == "./" {
+			continue
+		}
+		if strings.HasPrefix(hdr.Name, "output/") {
+			hdr.Name = hdr.Name[len("output/"):]
+		} else if strings.HasPrefix(hdr.Name, "source/") {
+			hdr.Name = hdr.Name[len("source/"):]
+		}
+		if len(hdr.Name) == 0 {
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
 }
 
 // CollectArtifact is copied from the build, we use this to get the layer
@@ -761,6 +668,10 @@ type DockerPushStep struct {
 	forceTags     bool
 	logger        *util.LogEntry
 	workingDir    string
+	runtimeBox    string
+	runtimeAuth   *core.BoxConfig
+	runtimeEnv    *util.Environment
+	runtimePaths  string
 }
 
 // NewDockerPushStep is a special step for doing docker pushes
@@ -782,6 +693,8 @@ func NewDockerPushStep(stepConfig *core.StepConfig, options *core.PipelineOption
 		Owner:       "wercker",
 		SafeID:      stepSafeID,
 		Version:     util.Version(),
+		Debug:       stepConfig.Debug,
+		Data:        stepConfig.Data,
 	})
 
 	return &DockerPushStep{
@@ -917,6 +830,24 @@ func (s *DockerPushStep) InitEnv(env *util.Environment) {
 		s.user = env.Interpolate(user)
 	}
 
+	if runtimeBox, ok := s.data["runtime-box"]; ok {
+		s.runtimeBox = env.Interpolate(runtimeBox)
+	}
+
+	// The runtime box interpolates these itself when it pulls
+	if s.data["runtime-box-username"] != "" || s.data["runtime-box-registry"] != "" {
+		s.runtimeAuth = &core.BoxConfig{
+			Username: s.data["runtime-box-username"],
+			Password: s.data["runtime-box-password"],
+			Registry: s.data["runtime-box-registry"],
+		}
+	}
+	s.runtimeEnv = env
+
+	if runtimePaths, ok := s.data["runtime-paths"]; ok {
+		s.runtimePaths = env.Interpolate(runtimePaths)
+	}
+
 	if forceTags, ok := s.data["force-tags"]; ok {
 		ft, err := strconv.ParseBool(forceTags)
 		if err == nil {
@@ -937,7 +868,7 @@ func (s *DockerPushStep) Fetch() (string, error) {
 // registry
 func (s *DockerPushStep) Execute(ctx context.Context, sess *core.Session) (int, error) {
 	// TODO(termie): could probably re-use the tansport's client
-	client, err := NewDockerClient(s.dockerOptions)
+	client, err := NewContainerRuntime(s.dockerOptions)
 	if err != nil {
 		return 1, err
 	}
@@ -955,8 +886,7 @@ func (s *DockerPushStep) Execute(ctx context.Context, sess *core.Session) (int,
 
 	// This is clearly only relevant to docker so we're going to dig into the
 	// transport internals a little bit to get the container ID
-	dt := sess.Transport().(*DockerTransport)
-	containerID := dt.containerID
+	containerID := sess.Transport().(containerTransport).ContainerID()
 
 	auth := docker.AuthConfiguration{
 		Username:      s.username,
@@ -973,7 +903,7 @@ func (s *DockerPushStep) Execute(ctx context.Context, sess *core.Session) (int,
 			Registry:   s.registry,
 		}
 
-		check, err := client.CheckAccess(checkOpts)
+		check, err := checkAccess(client, checkOpts)
 		if err != nil {
 			s.logger.Errorln("Error during check access", err)
 			return -1, err
@@ -1011,8 +941,14 @@ func (s *DockerPushStep) Execute(ctx context.Context, sess *core.Session) (int,
 		Tag:        s.options.PipelineID,
 	}
 
-	s.logger.Debugln("Commit container:", containerID)
-	i, err := client.CommitContainer(commitOpts)
+	var i *docker.Image
+	if s.runtimeBox != "" {
+		s.logger.Debugln("Commit runtime box:", s.runtimeBox)
+		i, err = s.commitRuntimeBox(ctx, client, containerID, commitOpts)
+	} else {
+		s.logger.Debugln("Commit container:", containerID)
+		i, err = client.CommitContainer(commitOpts)
+	}
 	if err != nil {
 		return -1, err
 	}
@@ -1021,7 +957,7 @@ func (s *DockerPushStep) Execute(ctx context.Context, sess *core.Session) (int,
 	return s.tagAndPush(i.ID, e, client, auth)
 }
 
-func (s *DockerPushStep) tagAndPush(imageID string, e *core.NormalizedEmitter, client *DockerClient, auth docker.AuthConfiguration) (int, error) {
+func (s *DockerPushStep) tagAndPush(imageID string, e *core.NormalizedEmitter, client ContainerRuntime, auth docker.AuthConfiguration) (int, error) {
 	// Create a pipe since we want a io.Reader but Docker expects a io.Writer
 	r, w := io.Pipe()
 
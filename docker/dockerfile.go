@@ -0,0 +1,149 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+	"golang.org/x/net/context"
+)
+
+// dockerfileHashSkip are left out of the content hash, the git metadata
+// changes far more often than anything that gets copied into a box
+var dockerfileHashSkip = []string{".git"}
+
+// dockerfileRepository is the repository boxes built from a Dockerfile are
+// tagged in, the tag is their content hash
+func dockerfileRepository(options *core.PipelineOptions) string {
+	name := strings.ToLower(containerSafe(options.ApplicationName))
+	if name == "" {
+		name = "box"
+	}
+	return "wercker-boxes/" + name
+}
+
+// dockerfileBuild has everything needed to build a box's Dockerfile
+type dockerfileBuild struct {
+	contextDir string
+	dockerfile string
+	buildArgs  []docker.BuildArg
+}
+
+// newDockerfileBuild resolves the Dockerfile and context against the
+// project path, the Dockerfile has to live inside the context
+func newDockerfileBuild(config *core.BoxConfig, projectPath string, env *util.Environment) (*dockerfileBuild, error) {
+	contextDir := filepath.Join(projectPath, config.Context)
+	if filepath.IsAbs(config.Context) {
+		contextDir = config.Context
+	}
+	dockerfilePath := filepath.Join(projectPath, config.Dockerfile)
+	if filepath.IsAbs(config.Dockerfile) {
+		dockerfilePath = config.Dockerfile
+	}
+	dockerfile, err := filepath.Rel(contextDir, dockerfilePath)
+	if err != nil || strings.HasPrefix(dockerfile, "..") {
+		return nil, fmt.Errorf("Dockerfile %s has to be inside the build context %s", config.Dockerfile, contextDir)
+	}
+
+	names := []string{}
+	for name := range config.BuildArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buildArgs := []docker.BuildArg{}
+	for _, name := range names {
+		buildArgs = append(buildArgs, docker.BuildArg{
+			Name:  name,
+			Value: env.Interpolate(config.BuildArgs[name]),
+		})
+	}
+
+	return &dockerfileBuild{
+		contextDir: contextDir,
+		dockerfile: filepath.ToSlash(dockerfile),
+		buildArgs:  buildArgs,
+	}, nil
+}
+
+// tag is a hash of the context, the Dockerfile's place in it and the build
+// args, so any change to those means a new image
+func (d *dockerfileBuild) tag() (string, error) {
+	contextHash, err := util.HashDir(d.contextDir, dockerfileHashSkip)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", contextHash, d.dockerfile)
+	for _, arg := range d.buildArgs {
+		fmt.Fprintf(h, "%s=%s\x00", arg.Name, arg.Value)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fetchDockerfile builds the box from its Dockerfile, unless an image of
+// the same content hash was built before
+func (b *DockerBox) fetchDockerfile(ctx context.Context, env *util.Environment) (*docker.Image, error) {
+	e, err := core.EmitterFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	build, err := newDockerfileBuild(b.config, b.options.ProjectPath, env)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := build.tag()
+	if err != nil {
+		return nil, err
+	}
+	b.tag = tag
+	b.Name = fmt.Sprintf("%s:%s", b.repository, tag)
+
+	image, err := b.client.InspectImage(b.Name)
+	if err == nil {
+		b.logger.Debugln("Reusing box built from", b.config.Dockerfile)
+		b.image = image
+		return image, nil
+	}
+
+	e.Emit(core.Logs, &core.LogsArgs{
+		Logs: fmt.Sprintf("Building box from %s\n", b.config.Dockerfile),
+	})
+	err = b.client.BuildImage(docker.BuildImageOptions{
+		Name:         b.Name,
+		Dockerfile:   build.dockerfile,
+		ContextDir:   build.contextDir,
+		BuildArgs:    build.buildArgs,
+		OutputStream: &logsWriter{e: e, stream: "docker"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	image, err = b.client.InspectImage(b.Name)
+	if err != nil {
+		return nil, err
+	}
+	b.image = image
+	return image, nil
+}
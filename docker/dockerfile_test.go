@@ -0,0 +1,109 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/internal/fakeruntime/sandbox"
+	"github.com/wercker/wercker/util"
+	"golang.org/x/net/context"
+)
+
+type DockerfileSuite struct {
+	*util.TestSuite
+}
+
+func TestDockerfileSuite(t *testing.T) {
+	suiteTester := &DockerfileSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *DockerfileSuite) project() string {
+	project := filepath.Join(s.WorkingDir(), "project")
+	s.Require().NoError(os.MkdirAll(filepath.Join(project, "ci"), 0755))
+	err := ioutil.WriteFile(filepath.Join(project, "ci", "Dockerfile"), []byte("FROM alpine\n"), 0644)
+	s.Require().NoError(err)
+	return project
+}
+
+func (s *DockerfileSuite) TestDockerfileOutsideContext() {
+	project := s.project()
+	_, err := newDockerfileBuild(&core.BoxConfig{Dockerfile: "ci/Dockerfile", Context: "src"}, project, util.NewEnvironment())
+	s.Error(err)
+
+	build, err := newDockerfileBuild(&core.BoxConfig{Dockerfile: "ci/Dockerfile"}, project, util.NewEnvironment())
+	s.Require().NoError(err)
+	s.Equal("ci/Dockerfile", build.dockerfile)
+	s.Equal(project, build.contextDir)
+}
+
+func (s *DockerfileSuite) TestTag() {
+	project := s.project()
+	env := util.NewEnvironment("GO_VERSION=1.7")
+	tag := func(config *core.BoxConfig) string {
+		build, err := newDockerfileBuild(config, project, env)
+		s.Require().NoError(err)
+		tag, err := build.tag()
+		s.Require().NoError(err)
+		return tag
+	}
+
+	config := &core.BoxConfig{Dockerfile: "ci/Dockerfile"}
+	first := tag(config)
+	s.Equal(first, tag(config))
+
+	withArgs := &core.BoxConfig{Dockerfile: "ci/Dockerfile", BuildArgs: map[string]string{"GO": "$GO_VERSION"}}
+	s.NotEqual(first, tag(withArgs))
+
+	err := ioutil.WriteFile(filepath.Join(project, "main.go"), []byte("package main\n"), 0644)
+	s.Require().NoError(err)
+	s.NotEqual(first, tag(config))
+}
+
+func (s *DockerfileSuite) TestFetchBuildsOnce() {
+	fake, err := sandbox.New(filepath.Join(s.WorkingDir(), "sandbox"))
+	s.Require().NoError(err)
+	defer fake.Close()
+	dockerOptions := &DockerOptions{Client: fake, DockerLocal: true}
+
+	options := &core.PipelineOptions{
+		GlobalOptions:   &core.GlobalOptions{},
+		ApplicationName: "My App",
+		ProjectPath:     s.project(),
+	}
+	config := &core.BoxConfig{Dockerfile: "ci/Dockerfile"}
+	ctx := core.NewEmitterContext(context.Background())
+
+	box, err := NewDockerBox(config, options, dockerOptions)
+	s.Require().NoError(err)
+	first, err := box.Fetch(ctx, util.NewEnvironment())
+	s.Require().NoError(err)
+	s.Contains(box.Name, "wercker-boxes/my-app:")
+
+	box, err = NewDockerBox(config, options, dockerOptions)
+	s.Require().NoError(err)
+	second, err := box.Fetch(ctx, util.NewEnvironment())
+	s.Require().NoError(err)
+	s.Equal(first.ID, second.ID)
+
+	_, err = NewDockerBox(&core.BoxConfig{ID: "alpine", Dockerfile: "ci/Dockerfile"}, options, dockerOptions)
+	s.Error(err)
+}
@@ -43,3 +43,17 @@ func EmitStatus(e *core.NormalizedEmitter, r io.Reader, options *core.PipelineOp
 		})
 	}
 }
+
+// logsWriter emits everything written to it as Logs events
+type logsWriter struct {
+	e      *core.NormalizedEmitter
+	stream string
+}
+
+func (w *logsWriter) Write(p []byte) (int, error) {
+	w.e.Emit(core.Logs, &core.LogsArgs{
+		Stream: w.stream,
+		Logs:   string(p),
+	})
+	return len(p), nil
+}
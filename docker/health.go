@@ -0,0 +1,305 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+	"golang.org/x/net/context"
+)
+
+const (
+	defaultHealthcheckTimeout  = 60 * time.Second
+	defaultHealthcheckInterval = time.Second
+	// healthcheckLogTail is how many lines of service logs we show when a
+	// service never became ready
+	healthcheckLogTail = "50"
+	// defaultHealthcheckImage has the nc and wget TCP and HTTP probes use
+	defaultHealthcheckImage = "busybox:latest"
+)
+
+// healthcheck is a validated HealthcheckConfig
+type healthcheck struct {
+	*core.HealthcheckConfig
+	timeout  time.Duration
+	interval time.Duration
+	image    string
+}
+
+// newHealthcheck checks the config, a nil config gives a nil healthcheck
+func newHealthcheck(config *core.HealthcheckConfig) (*healthcheck, error) {
+	if config == nil {
+		return nil, nil
+	}
+
+	kinds := 0
+	if config.TCP != 0 {
+		kinds++
+	}
+	if config.HTTP != "" {
+		kinds++
+		if _, err := url.Parse(config.HTTP); err != nil {
+			return nil, fmt.Errorf("Invalid healthcheck http url: %s", err)
+		}
+	}
+	if config.Cmd != "" {
+		kinds++
+	}
+	if kinds != 1 {
+		return nil, fmt.Errorf("A healthcheck needs exactly one of tcp, http or cmd")
+	}
+
+	h := &healthcheck{
+		HealthcheckConfig: config,
+		timeout:           defaultHealthcheckTimeout,
+		interval:          defaultHealthcheckInterval,
+		image:             defaultHealthcheckImage,
+	}
+	if config.Image != "" {
+		h.image = config.Image
+	}
+	var err error
+	if config.Timeout != "" {
+		h.timeout, err = time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid healthcheck timeout: %s", err)
+		}
+	}
+	if config.Interval != "" {
+		h.interval, err = time.ParseDuration(config.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid healthcheck interval: %s", err)
+		}
+	}
+	return h, nil
+}
+
+// probeCommand is what checks the service is ready. TCP and HTTP checks
+// run in a prober container on the pipeline network, they use ip to reach
+// the service; we can't count on reaching it from here, with a remote
+// docker host say. Commands run in the service itself.
+func (h *healthcheck) probeCommand(ip string) []string {
+	wait := strconv.Itoa(int(h.probeTimeout().Seconds()))
+	switch {
+	case h.TCP != 0:
+		return []string{"sh", "-c", fmt.Sprintf("nc -w %s %s %d </dev/null", wait, ip, h.TCP)}
+	case h.HTTP != "":
+		u, _ := url.Parse(h.HTTP)
+		if host := u.Host; host == "" || hostname(host) == "" || hostname(host) == "localhost" {
+			u.Host = ip
+			if port := portOf(host); port != "" {
+				u.Host = net.JoinHostPort(ip, port)
+			}
+		}
+		return []string{"wget", "-q", "-T", wait, "-O", "/dev/null", u.String()}
+	default:
+		return []string{"sh", "-c", h.Cmd}
+	}
+}
+
+// probeTimeout is how long a single probe gets, the interval rounded up to
+// whole seconds
+func (h *healthcheck) probeTimeout() time.Duration {
+	return time.Duration(math.Max(1, math.Ceil(h.interval.Seconds()))) * time.Second
+}
+
+// inService is whether the probe runs in the service container rather than
+// in a prober
+func (h *healthcheck) inService() bool {
+	return h.Cmd != ""
+}
+
+// probe returns nil once the service is ready, the probe command runs in
+// containerID. A probe that hangs counts as failed after probeTimeout.
+func (h *healthcheck) probe(ctx context.Context, client ContainerRuntime, containerID, ip string) error {
+	cmd := h.probeCommand(ip)
+	var out bytes.Buffer
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+		Container:    containerID,
+	})
+	if err != nil {
+		return err
+	}
+	started := make(chan error, 1)
+	go func() {
+		started <- client.StartExec(exec.ID, docker.StartExecOptions{
+			OutputStream: &out,
+			ErrorStream:  &out,
+		})
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(h.probeTimeout()):
+		return fmt.Errorf("%q timed out after %s", strings.Join(cmd, " "), h.probeTimeout())
+	case err := <-started:
+		if err != nil {
+			return err
+		}
+	}
+	inspect, err := client.InspectExec(exec.ID)
+	if err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("%q exited with %d: %s", strings.Join(cmd, " "), inspect.ExitCode, out.String())
+	}
+	return nil
+}
+
+// startProber starts a container from image on network for TCP and HTTP
+// probes to run in, remove it when done. The image is only pulled if it
+// isn't there and we're allowed to.
+func startProber(client ContainerRuntime, network, image string, local bool) (*docker.Container, error) {
+	if _, err := client.InspectImage(image); err == docker.ErrNoSuchImage {
+		if local {
+			return nil, fmt.Errorf("Healthcheck image %s is not available locally", image)
+		}
+		repository, tag := docker.ParseRepositoryTag(image)
+		err = client.PullImage(docker.PullImageOptions{Repository: repository, Tag: tag}, docker.AuthConfiguration{})
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	container, err := client.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{
+			Image: image,
+			Cmd:   []string{"sleep", "86400"},
+		},
+		HostConfig:       &docker.HostConfig{NetworkMode: network},
+		NetworkingConfig: networkingConfig(network, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := client.StartContainer(container.ID, nil); err != nil {
+		removeProber(client, container)
+		return nil, err
+	}
+	return container, nil
+}
+
+func removeProber(client ContainerRuntime, container *docker.Container) {
+	client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+}
+
+func hostname(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport
+	}
+	return host
+}
+
+func portOf(hostport string) string {
+	_, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return ""
+	}
+	return port
+}
+
+// WaitReady blocks until the service passes its healthcheck, services
+// without one are ready as soon as they've started
+func (b *InternalServiceBox) WaitReady(ctx context.Context) error {
+	if b.health == nil || b.container == nil {
+		return nil
+	}
+	e, err := core.EmitterFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	client := b.client
+
+	e.Emit(core.Logs, &core.LogsArgs{
+		Logs: fmt.Sprintf("Waiting for service %s to be ready\n", b.ShortName),
+	})
+
+	probeIn := b.container.ID
+	if !b.health.inService() {
+		prober, err := startProber(client, b.network, b.health.image, b.dockerOptions.DockerLocal)
+		if err != nil {
+			return err
+		}
+		defer removeProber(client, prober)
+		probeIn = prober.ID
+	}
+
+	deadline := time.After(b.health.timeout)
+	ticker := time.NewTicker(b.health.interval)
+	defer ticker.Stop()
+
+	for {
+		container, err := client.InspectContainer(b.container.ID)
+		if err != nil {
+			return err
+		}
+		if !container.State.Running {
+			return b.notReady(fmt.Sprintf("Service %s exited with %d before it was ready", b.ShortName, container.State.ExitCode))
+		}
+
+		ip := ""
+		if container.NetworkSettings != nil {
+			ip = container.NetworkSettings.Networks[b.network].IPAddress
+		}
+		err = b.health.probe(ctx, client, probeIn, ip)
+		if err == nil {
+			e.Emit(core.Logs, &core.LogsArgs{
+				Logs: fmt.Sprintf("Service %s is ready\n", b.ShortName),
+			})
+			return nil
+		}
+		b.logger.Debugln("Service not ready yet:", b.ShortName, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return b.notReady(fmt.Sprintf("Service %s was not ready after %s: %s", b.ShortName, b.health.timeout, err))
+		case <-ticker.C:
+		}
+	}
+}
+
+// notReady makes an error out of message and the tail of the service logs
+func (b *InternalServiceBox) notReady(message string) error {
+	var logs bytes.Buffer
+	err := b.client.Logs(docker.LogsOptions{
+		Container:    b.container.ID,
+		Stdout:       true,
+		Stderr:       true,
+		OutputStream: &logs,
+		ErrorStream:  &logs,
+		Tail:         healthcheckLogTail,
+	})
+	if err != nil || logs.Len() == 0 {
+		return fmt.Errorf("%s", message)
+	}
+	return fmt.Errorf("%s\nLast logs of service %s:\n%s", message, b.ShortName, logs.String())
+}
@@ -0,0 +1,133 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/internal/fakeruntime/sandbox"
+	"github.com/wercker/wercker/util"
+	"golang.org/x/net/context"
+)
+
+type HealthSuite struct {
+	*util.TestSuite
+}
+
+func TestHealthSuite(t *testing.T) {
+	suiteTester := &HealthSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *HealthSuite) TestNewHealthcheck() {
+	h, err := newHealthcheck(nil)
+	s.Nil(err)
+	s.Nil(h)
+
+	h, err = newHealthcheck(&core.HealthcheckConfig{TCP: 5432})
+	s.Require().Nil(err)
+	s.Equal(defaultHealthcheckTimeout, h.timeout)
+	s.Equal(defaultHealthcheckInterval, h.interval)
+	s.Equal(defaultHealthcheckImage, h.image)
+
+	h, err = newHealthcheck(&core.HealthcheckConfig{Cmd: "true", Timeout: "5s", Interval: "100ms", Image: "mirror/busybox"})
+	s.Require().Nil(err)
+	s.Equal("mirror/busybox", h.image)
+	s.Equal(5*time.Second, h.timeout)
+	s.Equal(100*time.Millisecond, h.interval)
+
+	invalid := []*core.HealthcheckConfig{
+		{},
+		{TCP: 80, Cmd: "true"},
+		{TCP: 80, Timeout: "soon"},
+		{TCP: 80, Interval: "often"},
+	}
+	for _, config := range invalid {
+		_, err := newHealthcheck(config)
+		s.Error(err, "%+v", config)
+	}
+}
+
+func (s *HealthSuite) TestProbeCommand() {
+	h, err := newHealthcheck(&core.HealthcheckConfig{TCP: 5432})
+	s.Require().Nil(err)
+	s.False(h.inService())
+	s.Equal([]string{"sh", "-c", "nc -w 1 10.0.0.2 5432 </dev/null"}, h.probeCommand("10.0.0.2"))
+
+	// localhost means the service itself
+	h, err = newHealthcheck(&core.HealthcheckConfig{HTTP: "http://localhost:8080/healthz", Interval: "3s"})
+	s.Require().Nil(err)
+	s.Equal([]string{"wget", "-q", "-T", "3", "-O", "/dev/null", "http://10.0.0.2:8080/healthz"}, h.probeCommand("10.0.0.2"))
+
+	h, err = newHealthcheck(&core.HealthcheckConfig{HTTP: "http://other/healthz"})
+	s.Require().Nil(err)
+	s.Equal("http://other/healthz", h.probeCommand("10.0.0.2")[6])
+
+	h, err = newHealthcheck(&core.HealthcheckConfig{Cmd: "pg_isready"})
+	s.Require().Nil(err)
+	s.True(h.inService())
+	s.Equal([]string{"sh", "-c", "pg_isready"}, h.probeCommand("10.0.0.2"))
+}
+
+func (s *HealthSuite) TestProber() {
+	fake, err := sandbox.New(s.WorkingDir())
+	s.Require().Nil(err)
+	defer fake.Close()
+
+	prober, err := startProber(fake, "wercker-network", defaultHealthcheckImage, false)
+	s.Require().Nil(err)
+	s.Equal(defaultHealthcheckImage, prober.Config.Image)
+
+	removeProber(fake, prober)
+	_, err = fake.InspectContainer(prober.ID)
+	s.Error(err)
+
+	// Local runs don't pull an image that isn't there
+	missing := "busybox:" + strings.Repeat("0", 64)
+	_, err = startProber(fake, "wercker-network", missing, true)
+	s.Error(err)
+}
+
+func (s *HealthSuite) TestProbeCmd() {
+	fake, err := sandbox.New(s.WorkingDir())
+	s.Require().Nil(err)
+	defer fake.Close()
+
+	container, err := fake.CreateContainer(docker.CreateContainerOptions{
+		Name:   "service",
+		Config: &docker.Config{Cmd: []string{"sleep", "60"}},
+	})
+	s.Require().Nil(err)
+	s.Require().Nil(fake.StartContainer(container.ID, nil))
+
+	h, err := newHealthcheck(&core.HealthcheckConfig{Cmd: "test -e ready"})
+	s.Require().Nil(err)
+	s.Error(h.probe(context.Background(), fake, container.ID, ""))
+
+	ready, err := newHealthcheck(&core.HealthcheckConfig{Cmd: "true"})
+	s.Require().Nil(err)
+	s.Nil(ready.probe(context.Background(), fake, container.ID, ""))
+
+	hung, err := newHealthcheck(&core.HealthcheckConfig{Cmd: "sleep 60", Interval: "1s"})
+	s.Require().Nil(err)
+	started := time.Now()
+	s.Error(hung.probe(context.Background(), fake, container.ID, ""))
+	s.True(time.Since(started) < 10*time.Second, "a hung probe times out")
+}
@@ -0,0 +1,188 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+	"golang.org/x/net/context"
+)
+
+// hostBoxID is the box id, and the container id, of the host box
+const hostBoxID = "host"
+
+// isHost tells us if a box id or container id means the host box
+func isHost(id string) bool {
+	return id == hostBoxID
+}
+
+// localShell runs command lines on this machine
+type localShell struct{}
+
+// localShellEnv is all the host box gets of our own environment, which has
+// secrets of ours in it. The pipeline env comes through the session.
+var localShellEnv = []string{"PATH", "HOME", "USER"}
+
+func (localShell) command(tty bool, line string) *exec.Cmd {
+	cmd := exec.Command("/bin/sh", "-c", line)
+	cmd.Env = []string{}
+	for _, name := range localShellEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", name, value))
+		}
+	}
+	return cmd
+}
+
+// HostBox runs the pipeline straight on this machine, in a scratch copy of
+// the source next to the build directory. There is no isolation at all so
+// it's only meant for trusted, quick runs like linting.
+type HostBox struct {
+	Name     string
+	config   *core.BoxConfig
+	options  *core.PipelineOptions
+	services []core.ServiceBox
+	root     string
+	cmd      string
+	env      []string
+	logger   *util.LogEntry
+}
+
+// NewHostBox for `box: host`
+func NewHostBox(boxConfig *core.BoxConfig, options *core.PipelineOptions) (*HostBox, error) {
+	cmd := boxConfig.Cmd
+	if cmd == "" {
+		cmd = "/bin/bash"
+	}
+
+	// Steps find their way around through the options, so point them at
+	// the scratch directory, or at the build directory itself when we
+	// aren't supposed to copy the source
+	root := options.BuildPath(options.PipelineID + "-host")
+	options.GuestRoot = path.Join(root, "pipeline")
+	options.MntRoot = path.Join(root, "mnt")
+	options.ReportRoot = path.Join(root, "report")
+	if options.DirectMount {
+		options.GuestRoot = options.HostPath()
+		options.MntRoot = options.HostPath()
+	}
+
+	logger := util.RootLogger().WithFields(util.LogFields{
+		"Logger": "HostBox",
+		"Name":   hostBoxID,
+	})
+
+	return &HostBox{
+		Name:    hostBoxID,
+		config:  boxConfig,
+		options: options,
+		root:    root,
+		cmd:     cmd,
+		logger:  logger,
+	}, nil
+}
+
+// GetName gets the box name
+func (b *HostBox) GetName() string {
+	return b.Name
+}
+
+// GetTag is always empty, there is no image
+func (b *HostBox) GetTag() string {
+	return ""
+}
+
+// AddService needed by this Box
+func (b *HostBox) AddService(service core.ServiceBox) {
+	b.services = append(b.services, service)
+}
+
+// Services returns the service boxes attached to this box
+func (b *HostBox) Services() []core.ServiceBox {
+	return b.services
+}
+
+// Fetch creates the scratch directory, there is nothing to pull
+func (b *HostBox) Fetch(ctx context.Context, env *util.Environment) (*docker.Image, error) {
+	if err := os.MkdirAll(b.root, 0755); err != nil {
+		return nil, err
+	}
+	return &docker.Image{ID: b.Name}, nil
+}
+
+// Run makes the scratch copy of the source and cache, the "container" it
+// returns is the host box id
+func (b *HostBox) Run(ctx context.Context, env *util.Environment) (*docker.Container, error) {
+	if len(b.services) > 0 {
+		return nil, fmt.Errorf("Services are not supported on the host box")
+	}
+	b.env = dockerEnv(b.config.Env, env)
+	if !b.options.DirectMount {
+		if err := shellUpload(localShell{}, b.options.HostPath(), b.options.MntRoot, b.options.WorkingDir); err != nil {
+			return nil, err
+		}
+	}
+	return &docker.Container{ID: hostBoxID, Name: b.Name}, nil
+}
+
+// Restart gives us the same "container", the shell is per session anyway
+func (b *HostBox) Restart() (*docker.Container, error) {
+	return &docker.Container{ID: hostBoxID, Name: b.Name}, nil
+}
+
+// Stop does nothing, sessions end when their shell does
+func (b *HostBox) Stop() {
+	b.logger.Debugln("Nothing to stop on the host box")
+}
+
+// Clean removes the scratch directory
+func (b *HostBox) Clean() error {
+	return os.RemoveAll(b.root)
+}
+
+// Commit can't make an image out of a directory
+func (b *HostBox) Commit(ctx context.Context, name, tag, message string) (*docker.Image, error) {
+	return nil, fmt.Errorf("Can't commit the host box")
+}
+
+// OOMKilled is never true, we don't limit the shell
+func (b *HostBox) OOMKilled() bool {
+	return false
+}
+
+// RecoverInteractive opens a shell with the environment of the failed step
+func (b *HostBox) RecoverInteractive(cwd string, pipeline core.Pipeline, step core.Step) error {
+	return b.AttachInteractive(cwd, pipeline, step)
+}
+
+// AttachInteractive opens a shell with the environment of step
+func (b *HostBox) AttachInteractive(cwd string, pipeline core.Pipeline, step core.Step) error {
+	return shellInteractive(localShell{}, b.root, cwd, b.cmd, pipeline, step)
+}
+
+// Transport is the shell the session runs in
+func (b *HostBox) Transport(containerID string) (core.Transport, error) {
+	line, err := shellSession(localShell{}, b.root, b.env, b.cmd)
+	if err != nil {
+		return nil, err
+	}
+	return NewShellTransport(localShell{}, line, hostBoxID), nil
+}
@@ -0,0 +1,311 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Media types of the image layout we write
+const (
+	ociIndexMediaType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigMediaType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerMediaType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *ociPlatform      `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// imageConfig is the image config, the same for Docker v2.2 and OCI
+type imageConfig struct {
+	Created      time.Time      `json:"created"`
+	Author       string         `json:"author,omitempty"`
+	Architecture string         `json:"architecture"`
+	Variant      string         `json:"variant,omitempty"`
+	OS           string         `json:"os"`
+	Config       imageRunConfig `json:"config"`
+	RootFS       imageRootFS    `json:"rootfs"`
+	History      []imageHistory `json:"history,omitempty"`
+}
+
+type imageRunConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+type imageRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type imageHistory struct {
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+}
+
+// dockerManifestEntry is what docker load looks for in manifest.json
+type dockerManifestEntry struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// imageLayer is a gzipped layer on disk
+type imageLayer struct {
+	path string
+	// diffID is the digest of the uncompressed tar, digest that of the gzip
+	diffID string
+	digest string
+	size   int64
+}
+
+// parsePlatform reads "arm64", "arm/v7" or "linux/arm/v7" style platforms
+func parsePlatform(platform string) ociPlatform {
+	parts := strings.Split(platform, "/")
+	p := ociPlatform{OS: "linux"}
+	if len(parts) == 3 || (len(parts) == 2 && !strings.HasPrefix(parts[1], "v")) {
+		p.OS, parts = parts[0], parts[1:]
+	}
+	p.Architecture = parts[0]
+	if len(parts) > 1 {
+		p.Variant = parts[1]
+	}
+	return p
+}
+
+// daemonPlatform is the platform the runtime runs images on, or ours if it
+// won't say
+func daemonPlatform(client ContainerRuntime) ociPlatform {
+	platform := ociPlatform{Architecture: runtime.GOARCH, OS: "linux"}
+	if version, err := client.Version(); err == nil {
+		if arch := version.Get("Arch"); arch != "" {
+			platform.Architecture = arch
+		}
+		if osName := version.Get("Os"); osName != "" {
+			platform.OS = osName
+		}
+	}
+	return platform
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// writeLayer gzips the tarball from r to path, keeping track of the digests
+// on both sides of the compression
+func writeLayer(r io.Reader, path string) (*imageLayer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	compressed := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(f, compressed)}
+	gz := gzip.NewWriter(counter)
+	uncompressed := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(gz, uncompressed), r); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return &imageLayer{
+		path:   path,
+		diffID: "sha256:" + hex.EncodeToString(uncompressed.Sum(nil)),
+		digest: "sha256:" + hex.EncodeToString(compressed.Sum(nil)),
+		size:   counter.n,
+	}, nil
+}
+
+// blobPath is where a blob lives in the image layout
+func blobPath(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// writeImageArchive writes a single layer image as an OCI image layout with
+// a manifest.json next to it for docker load. It returns the image ID.
+func writeImageArchive(w io.Writer, config *imageConfig, layer *imageLayer, repoTags []string) (string, error) {
+	config.RootFS = imageRootFS{Type: "layers", DiffIDs: []string{layer.diffID}}
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	configDigest := digestOf(configJSON)
+
+	manifestJSON, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		Config: ociDescriptor{
+			MediaType: ociConfigMediaType,
+			Digest:    configDigest,
+			Size:      int64(len(configJSON)),
+		},
+		Layers: []ociDescriptor{{
+			MediaType: ociLayerMediaType,
+			Digest:    layer.digest,
+			Size:      layer.size,
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	manifests := []ociDescriptor{}
+	for _, repoTag := range repoTags {
+		tag := repoTag[strings.LastIndex(repoTag, ":")+1:]
+		manifests = append(manifests, ociDescriptor{
+			MediaType: ociManifestMediaType,
+			Digest:    digestOf(manifestJSON),
+			Size:      int64(len(manifestJSON)),
+			Platform: &ociPlatform{
+				Architecture: config.Architecture,
+				OS:           config.OS,
+				Variant:      config.Variant,
+			},
+			Annotations: map[string]string{
+				"io.containerd.image.name":          repoTag,
+				"org.opencontainers.image.ref.name": tag,
+			},
+		})
+	}
+	indexJSON, err := json.Marshal(ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociIndexMediaType,
+		Manifests:     manifests,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	dockerManifestJSON, err := json.Marshal([]dockerManifestEntry{{
+		Config:   blobPath(configDigest),
+		RepoTags: repoTags,
+		Layers:   []string{blobPath(layer.digest)},
+	}})
+	if err != nil {
+		return "", err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+		if err := tw.WriteHeader(&tar.Header{Name: dir, Typeflag: tar.TypeDir, Mode: 0755, ModTime: config.Created}); err != nil {
+			return "", err
+		}
+	}
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)},
+		{blobPath(configDigest), configJSON},
+		{blobPath(digestOf(manifestJSON)), manifestJSON},
+		{"index.json", indexJSON},
+		{"manifest.json", dockerManifestJSON},
+	}
+	for _, file := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name:     file.name,
+			Mode:     0644,
+			Size:     int64(len(file.content)),
+			ModTime:  config.Created,
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			return "", err
+		}
+		if _, err := tw.Write(file.content); err != nil {
+			return "", err
+		}
+	}
+
+	layerFile, err := os.Open(layer.path)
+	if err != nil {
+		return "", err
+	}
+	defer layerFile.Close()
+	err = tw.WriteHeader(&tar.Header{
+		Name:     blobPath(layer.digest),
+		Mode:     0644,
+		Size:     layer.size,
+		ModTime:  config.Created,
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tw, layerFile); err != nil {
+		return "", err
+	}
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	return configDigest, nil
+}
@@ -0,0 +1,118 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/util"
+)
+
+type ImageSuite struct {
+	*util.TestSuite
+}
+
+func TestImageSuite(t *testing.T) {
+	suiteTester := &ImageSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *ImageSuite) TestParsePlatform() {
+	s.Equal(ociPlatform{OS: "linux", Architecture: "arm64"}, parsePlatform("arm64"))
+	s.Equal(ociPlatform{OS: "linux", Architecture: "arm", Variant: "v7"}, parsePlatform("arm/v7"))
+	s.Equal(ociPlatform{OS: "windows", Architecture: "amd64"}, parsePlatform("windows/amd64"))
+	s.Equal(ociPlatform{OS: "linux", Architecture: "arm", Variant: "v6"}, parsePlatform("linux/arm/v6"))
+}
+
+func (s *ImageSuite) TestWriteImageArchive() {
+	dir, err := ioutil.TempDir("", "image-test")
+	s.Require().NoError(err)
+	defer os.RemoveAll(dir)
+
+	var layerTar bytes.Buffer
+	tw := tar.NewWriter(&layerTar)
+	s.Require().NoError(tw.WriteHeader(&tar.Header{Name: "hello", Mode: 0644, Size: 5}))
+	_, err = tw.Write([]byte("hello"))
+	s.Require().NoError(err)
+	s.Require().NoError(tw.Close())
+
+	layer, err := writeLayer(bytes.NewReader(layerTar.Bytes()), filepath.Join(dir, "layer.tar.gz"))
+	s.Require().NoError(err)
+	s.Equal(digestOf(layerTar.Bytes()), layer.diffID)
+	gzipped, err := ioutil.ReadFile(layer.path)
+	s.Require().NoError(err)
+	s.Equal(digestOf(gzipped), layer.digest)
+	s.Equal(int64(len(gzipped)), layer.size)
+
+	config := &imageConfig{
+		Created:      time.Unix(0, 0).UTC(),
+		Architecture: "arm",
+		Variant:      "v7",
+		OS:           "linux",
+		Config:       imageRunConfig{Cmd: []string{"/hello"}},
+	}
+	var archive bytes.Buffer
+	imageID, err := writeImageArchive(&archive, config, layer, []string{"example/hello:latest"})
+	s.Require().NoError(err)
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		s.Require().NoError(err)
+		files[hdr.Name], err = ioutil.ReadAll(tr)
+		s.Require().NoError(err)
+	}
+
+	s.Contains(files, "oci-layout")
+	s.Equal(gzipped, files[blobPath(layer.digest)])
+
+	configJSON := files[blobPath(imageID)]
+	s.Equal(imageID, digestOf(configJSON))
+	var gotConfig imageConfig
+	s.Require().NoError(json.Unmarshal(configJSON, &gotConfig))
+	s.Equal([]string{layer.diffID}, gotConfig.RootFS.DiffIDs)
+	s.Equal([]string{"/hello"}, gotConfig.Config.Cmd)
+
+	var index ociIndex
+	s.Require().NoError(json.Unmarshal(files["index.json"], &index))
+	s.Require().Len(index.Manifests, 1)
+	s.Equal("arm", index.Manifests[0].Platform.Architecture)
+	s.Equal("v7", index.Manifests[0].Platform.Variant)
+	s.Equal("latest", index.Manifests[0].Annotations["org.opencontainers.image.ref.name"])
+
+	var manifest ociManifest
+	s.Require().NoError(json.Unmarshal(files[blobPath(index.Manifests[0].Digest)], &manifest))
+	s.Equal(imageID, manifest.Config.Digest)
+	s.Equal(layer.digest, manifest.Layers[0].Digest)
+
+	var entries []dockerManifestEntry
+	s.Require().NoError(json.Unmarshal(files["manifest.json"], &entries))
+	s.Require().Len(entries, 1)
+	s.Equal([]string{"example/hello:latest"}, entries[0].RepoTags)
+	s.Equal(blobPath(imageID), entries[0].Config)
+}
@@ -0,0 +1,175 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+)
+
+const (
+	// keptServiceLabel marks service containers that outlive the pipeline
+	// (--keep-services), its value is the project they belong to
+	keptServiceLabel = "sh.wercker.kept-service"
+	// serviceHashLabel is the hash of everything a kept service container
+	// was created from
+	serviceHashLabel = "sh.wercker.service-hash"
+)
+
+var unsafeNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// containerSafe turns name into something docker accepts in container and
+// network names
+func containerSafe(name string) string {
+	return strings.Trim(unsafeNameChars.ReplaceAllString(name, "-"), "-._")
+}
+
+// keptServicesProject is what kept services are grouped by, it has to come
+// out the same on every run of a project
+func keptServicesProject(options *core.PipelineOptions) string {
+	return containerSafe(options.ApplicationOwnerName + "-" + options.ApplicationName)
+}
+
+// keptNetworkName is the network kept services of project live on, it
+// outlives the pipeline along with them
+func keptNetworkName(project string) string {
+	return "wercker-services-" + project
+}
+
+// keptServiceName is the container name of a kept service
+func keptServiceName(project, name string) string {
+	return "wercker-service-" + project + "-" + containerSafe(name)
+}
+
+// serviceHash sums up what a service container is created from, a kept
+// container is only reused when none of it changed
+func serviceHash(imageID string, opts docker.CreateContainerOptions) (string, error) {
+	h := sha256.New()
+	err := json.NewEncoder(h).Encode(struct {
+		ImageID          string
+		Config           *docker.Config
+		HostConfig       *docker.HostConfig
+		NetworkingConfig *docker.NetworkingConfig
+	}{imageID, opts.Config, opts.HostConfig, opts.NetworkingConfig})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// reusableService looks for a container called name that was created with
+// the same hash and starts it again if it was stopped. A container that
+// doesn't match is removed to make room for a fresh one.
+func reusableService(client ContainerRuntime, name, hash string) (*docker.Container, error) {
+	container, err := client.InspectContainer(name)
+	if _, ok := err.(*docker.NoSuchContainer); ok {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if container.Config != nil && container.Config.Labels[serviceHashLabel] == hash {
+		if container.State.Running {
+			return container, nil
+		}
+		if err := client.StartContainer(container.ID, nil); err == nil {
+			return container, nil
+		}
+	}
+	err = client.RemoveContainer(docker.RemoveContainerOptions{
+		ID:            container.ID,
+		RemoveVolumes: true,
+		Force:         true,
+	})
+	return nil, err
+}
+
+// KeptService is a service container left behind by --keep-services
+type KeptService struct {
+	ID      string
+	Name    string
+	Project string
+	Image   string
+	Status  string
+}
+
+// ListKeptServices finds all kept service containers, running or not
+func ListKeptServices(client ContainerRuntime) ([]*KeptService, error) {
+	containers, err := client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": {keptServiceLabel}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	services := []*KeptService{}
+	for _, container := range containers {
+		name := container.ID
+		if len(container.Names) > 0 {
+			name = strings.TrimPrefix(container.Names[0], "/")
+		}
+		services = append(services, &KeptService{
+			ID:      container.ID,
+			Name:    name,
+			Project: container.Labels[keptServiceLabel],
+			Image:   container.Image,
+			Status:  container.Status,
+		})
+	}
+	return services, nil
+}
+
+// StopKeptService stops a kept service, the next run starts it again
+func StopKeptService(client ContainerRuntime, service *KeptService) error {
+	err := client.StopContainer(service.ID, 10)
+	if _, ok := err.(*docker.ContainerNotRunning); ok {
+		return nil
+	}
+	return err
+}
+
+// RemoveKeptService removes a kept service and, once the last service of
+// its project is gone, the project's network
+func RemoveKeptService(client ContainerRuntime, service *KeptService) error {
+	err := client.RemoveContainer(docker.RemoveContainerOptions{
+		ID:            service.ID,
+		RemoveVolumes: true,
+		Force:         true,
+	})
+	if err != nil {
+		return err
+	}
+
+	remaining, err := ListKeptServices(client)
+	if err != nil {
+		return err
+	}
+	for _, other := range remaining {
+		if other.Project == service.Project {
+			return nil
+		}
+	}
+	err = client.RemoveNetwork(keptNetworkName(service.Project))
+	if _, ok := err.(*docker.NoSuchNetwork); ok {
+		return nil
+	}
+	return err
+}
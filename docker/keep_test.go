@@ -0,0 +1,105 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/internal/fakeruntime/sandbox"
+	"github.com/wercker/wercker/util"
+)
+
+type KeepSuite struct {
+	*util.TestSuite
+}
+
+func TestKeepSuite(t *testing.T) {
+	suiteTester := &KeepSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *KeepSuite) TestNames() {
+	options := &core.PipelineOptions{
+		ApplicationOwnerName: "jane doe",
+		ApplicationName:      "my/app",
+	}
+	project := keptServicesProject(options)
+	s.Equal("jane-doe-my-app", project)
+	s.Equal("wercker-service-jane-doe-my-app-elasticsearch", keptServiceName(project, "elasticsearch"))
+	s.Equal("wercker-services-jane-doe-my-app", keptNetworkName(project))
+}
+
+func (s *KeepSuite) TestServiceHash() {
+	opts := func(env ...string) docker.CreateContainerOptions {
+		return docker.CreateContainerOptions{
+			Config: &docker.Config{Image: "elasticsearch", Env: env},
+		}
+	}
+	a, err := serviceHash("sha256:1", opts("A=1"))
+	s.Require().NoError(err)
+	b, err := serviceHash("sha256:1", opts("A=1"))
+	s.Require().NoError(err)
+	s.Equal(a, b)
+
+	changedEnv, err := serviceHash("sha256:1", opts("A=2"))
+	s.Require().NoError(err)
+	s.NotEqual(a, changedEnv)
+
+	changedImage, err := serviceHash("sha256:2", opts("A=1"))
+	s.Require().NoError(err)
+	s.NotEqual(a, changedImage)
+}
+
+func (s *KeepSuite) TestReusableService() {
+	fake, err := sandbox.New(filepath.Join(s.WorkingDir(), "sandbox"))
+	s.Require().NoError(err)
+	defer fake.Close()
+
+	container, err := reusableService(fake, "kept", "hash")
+	s.NoError(err)
+	s.Nil(container)
+
+	created, err := fake.CreateContainer(docker.CreateContainerOptions{
+		Name: "kept",
+		Config: &docker.Config{
+			Cmd:    []string{"sleep", "60"},
+			Labels: map[string]string{keptServiceLabel: "project", serviceHashLabel: "hash"},
+		},
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(fake.StartContainer(created.ID, nil))
+
+	container, err = reusableService(fake, "kept", "hash")
+	s.NoError(err)
+	s.Require().NotNil(container)
+	s.Equal(created.ID, container.ID)
+
+	services, err := ListKeptServices(fake)
+	s.NoError(err)
+	s.Require().Len(services, 1)
+	s.Equal("kept", services[0].Name)
+	s.Equal("project", services[0].Project)
+
+	// Anything that changed means a fresh container
+	container, err = reusableService(fake, "kept", "other")
+	s.NoError(err)
+	s.Nil(container)
+	_, err = fake.InspectContainer("kept")
+	s.IsType(&docker.NoSuchContainer{}, err)
+}
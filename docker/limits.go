@@ -0,0 +1,106 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+)
+
+// cpuPeriod is the CFS period we use to turn `cpus` into a quota, it is
+// the same default the docker cli uses for --cpus
+const cpuPeriod = 100000
+
+// oomCheckCmd prints the cgroup OOM counters for both cgroup v2 and v1
+var oomCheckCmd = []string{
+	"sh", "-c",
+	"cat /sys/fs/cgroup/memory.events /sys/fs/cgroup/memory/memory.oom_control 2>/dev/null",
+}
+
+// resourceLimits applies the limits from the box config to a HostConfig
+func resourceLimits(config *core.BoxConfig, hostConfig *docker.HostConfig) error {
+	if config.Memory != "" {
+		memory, err := units.RAMInBytes(config.Memory)
+		if err != nil {
+			return fmt.Errorf("Invalid memory limit %q: %s", config.Memory, err)
+		}
+		hostConfig.Memory = memory
+		// No extra swap on top of the memory limit
+		hostConfig.MemorySwap = memory
+	}
+
+	if config.CPUs < 0 {
+		return fmt.Errorf("Invalid cpus limit: %v", config.CPUs)
+	}
+	if config.CPUs > 0 {
+		hostConfig.CPUPeriod = cpuPeriod
+		hostConfig.CPUQuota = int64(config.CPUs * cpuPeriod)
+	}
+
+	if config.PidsLimit < 0 {
+		return fmt.Errorf("Invalid pids-limit: %d", config.PidsLimit)
+	}
+	hostConfig.PidsLimit = config.PidsLimit
+
+	if config.ShmSize != "" {
+		shmSize, err := units.RAMInBytes(config.ShmSize)
+		if err != nil {
+			return fmt.Errorf("Invalid shm-size %q: %s", config.ShmSize, err)
+		}
+		hostConfig.ShmSize = shmSize
+	}
+
+	names := []string{}
+	for name := range config.Ulimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := config.Ulimits[name]
+		ulimit, err := units.ParseUlimit(fmt.Sprintf("%s=%s", name, value))
+		if err != nil {
+			return fmt.Errorf("Invalid ulimit %s: %s", name, err)
+		}
+		hostConfig.Ulimits = append(hostConfig.Ulimits, docker.ULimit{
+			Name: ulimit.Name,
+			Soft: ulimit.Soft,
+			Hard: ulimit.Hard,
+		})
+	}
+	return nil
+}
+
+// oomKillCount finds the oom_kill counter in the output of oomCheckCmd
+func oomKillCount(output string) int {
+	count := 0
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "oom_kill" {
+			continue
+		}
+		if n, err := strconv.Atoi(fields[1]); err == nil {
+			count += n
+		}
+	}
+	return count
+}
@@ -0,0 +1,82 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+)
+
+type LimitsSuite struct {
+	*util.TestSuite
+}
+
+func TestLimitsSuite(t *testing.T) {
+	suiteTester := &LimitsSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *LimitsSuite) TestResourceLimits() {
+	config := &core.BoxConfig{
+		Memory:    "512m",
+		CPUs:      1.5,
+		PidsLimit: 100,
+		ShmSize:   "64m",
+		Ulimits: map[string]string{
+			"nproc":  "64",
+			"nofile": "1024:2048",
+		},
+	}
+	hostConfig := &docker.HostConfig{}
+	s.Require().NoError(resourceLimits(config, hostConfig))
+
+	s.Equal(int64(512*1024*1024), hostConfig.Memory)
+	s.Equal(hostConfig.Memory, hostConfig.MemorySwap)
+	s.Equal(int64(100000), hostConfig.CPUPeriod)
+	s.Equal(int64(150000), hostConfig.CPUQuota)
+	s.Equal(int64(100), hostConfig.PidsLimit)
+	s.Equal(int64(64*1024*1024), hostConfig.ShmSize)
+	s.Equal([]docker.ULimit{
+		{Name: "nofile", Soft: 1024, Hard: 2048},
+		{Name: "nproc", Soft: 64, Hard: 64},
+	}, hostConfig.Ulimits)
+}
+
+func (s *LimitsSuite) TestResourceLimitsInvalid() {
+	invalid := []*core.BoxConfig{
+		{Memory: "lots"},
+		{CPUs: -1},
+		{PidsLimit: -1},
+		{ShmSize: "64x"},
+		{Ulimits: map[string]string{"nofile": "many"}},
+	}
+	for _, config := range invalid {
+		s.Error(resourceLimits(config, &docker.HostConfig{}), "%+v", config)
+	}
+}
+
+func (s *LimitsSuite) TestOOMKillCount() {
+	v2 := "low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\n"
+	s.Equal(1, oomKillCount(v2))
+
+	v1 := "oom_kill_disable 0\nunder_oom 0\noom_kill 3\n"
+	s.Equal(3, oomKillCount(v1))
+
+	s.Equal(0, oomKillCount(""))
+}
@@ -0,0 +1,108 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+)
+
+// privilegedError explains how to allow what the box asked for
+func privilegedError(what string) error {
+	return fmt.Errorf("Box requests %s, run with --allow-privileged to allow it", what)
+}
+
+// isHostVolume tells named volumes ("cache:/cache") apart from host paths
+func isHostVolume(source string) bool {
+	return strings.HasPrefix(source, "/") || strings.HasPrefix(source, ".") || strings.HasPrefix(source, "~")
+}
+
+// boxMounts adds the extra volumes, tmpfs mounts, capabilities and devices
+// from the box config to a HostConfig, refusing anything that reaches into
+// the host unless allowPrivileged is set
+func boxMounts(config *core.BoxConfig, allowPrivileged bool, hostConfig *docker.HostConfig) error {
+	for _, volume := range config.Volumes {
+		parts := strings.Split(volume, ":")
+		if len(parts) < 2 || len(parts) > 3 || !path.IsAbs(parts[1]) {
+			return fmt.Errorf("Invalid volume %q, expected name:/path[:ro]", volume)
+		}
+		if len(parts) == 3 && parts[2] != "ro" && parts[2] != "rw" {
+			return fmt.Errorf("Invalid volume mode in %q, expected ro or rw", volume)
+		}
+		if isHostVolume(parts[0]) && !allowPrivileged {
+			return privilegedError(fmt.Sprintf("host path volume %s", parts[0]))
+		}
+		hostConfig.Binds = append(hostConfig.Binds, volume)
+	}
+
+	for _, tmpfs := range config.Tmpfs {
+		parts := strings.SplitN(tmpfs, ":", 2)
+		if !path.IsAbs(parts[0]) {
+			return fmt.Errorf("Invalid tmpfs %q, expected /path[:options]", tmpfs)
+		}
+		if hostConfig.Tmpfs == nil {
+			hostConfig.Tmpfs = map[string]string{}
+		}
+		options := ""
+		if len(parts) > 1 {
+			options = parts[1]
+		}
+		hostConfig.Tmpfs[parts[0]] = options
+	}
+
+	// Dropping capabilities only ever makes the box safer
+	hostConfig.CapDrop = append(hostConfig.CapDrop, config.CapDrop...)
+
+	if len(config.CapAdd) > 0 {
+		if !allowPrivileged {
+			return privilegedError(fmt.Sprintf("capabilities %s", strings.Join(config.CapAdd, ", ")))
+		}
+		hostConfig.CapAdd = append(hostConfig.CapAdd, config.CapAdd...)
+	}
+
+	if config.Privileged {
+		if !allowPrivileged {
+			return privilegedError("privileged mode")
+		}
+		hostConfig.Privileged = true
+	}
+
+	for _, device := range config.Devices {
+		if !allowPrivileged {
+			return privilegedError(fmt.Sprintf("device %s", device))
+		}
+		parts := strings.Split(device, ":")
+		if len(parts) > 3 || !path.IsAbs(parts[0]) {
+			return fmt.Errorf("Invalid device %q, expected /host/path[:/container/path[:permissions]]", device)
+		}
+		d := docker.Device{
+			PathOnHost:        parts[0],
+			PathInContainer:   parts[0],
+			CgroupPermissions: "rwm",
+		}
+		if len(parts) > 1 {
+			d.PathInContainer = parts[1]
+		}
+		if len(parts) > 2 {
+			d.CgroupPermissions = parts[2]
+		}
+		hostConfig.Devices = append(hostConfig.Devices, d)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+)
+
+type MountsSuite struct {
+	*util.TestSuite
+}
+
+func TestMountsSuite(t *testing.T) {
+	suiteTester := &MountsSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *MountsSuite) TestUnprivileged() {
+	config := &core.BoxConfig{
+		Volumes: []string{"cache:/cache", "data:/data:ro"},
+		Tmpfs:   []string{"/tmp", "/run:size=64m"},
+		CapDrop: []string{"NET_RAW"},
+	}
+	hostConfig := &docker.HostConfig{Binds: []string{"/host:/mnt:ro"}}
+	s.Require().NoError(boxMounts(config, false, hostConfig))
+
+	s.Equal([]string{"/host:/mnt:ro", "cache:/cache", "data:/data:ro"}, hostConfig.Binds)
+	s.Equal(map[string]string{"/tmp": "", "/run": "size=64m"}, hostConfig.Tmpfs)
+	s.Equal([]string{"NET_RAW"}, hostConfig.CapDrop)
+	s.False(hostConfig.Privileged)
+}
+
+func (s *MountsSuite) TestPrivilegedNeedsFlag() {
+	configs := []*core.BoxConfig{
+		{Volumes: []string{"/var/run/docker.sock:/var/run/docker.sock"}},
+		{CapAdd: []string{"SYS_ADMIN"}},
+		{Privileged: true},
+		{Devices: []string{"/dev/fuse"}},
+	}
+	for _, config := range configs {
+		s.Error(boxMounts(config, false, &docker.HostConfig{}), "%+v", config)
+		s.NoError(boxMounts(config, true, &docker.HostConfig{}), "%+v", config)
+	}
+}
+
+func (s *MountsSuite) TestPrivileged() {
+	config := &core.BoxConfig{
+		CapAdd:     []string{"SYS_ADMIN"},
+		Privileged: true,
+		Devices:    []string{"/dev/fuse", "/dev/sda:/dev/xvda:r"},
+	}
+	hostConfig := &docker.HostConfig{}
+	s.Require().NoError(boxMounts(config, true, hostConfig))
+
+	s.True(hostConfig.Privileged)
+	s.Equal([]string{"SYS_ADMIN"}, hostConfig.CapAdd)
+	s.Equal([]docker.Device{
+		{PathOnHost: "/dev/fuse", PathInContainer: "/dev/fuse", CgroupPermissions: "rwm"},
+		{PathOnHost: "/dev/sda", PathInContainer: "/dev/xvda", CgroupPermissions: "r"},
+	}, hostConfig.Devices)
+}
+
+func (s *MountsSuite) TestInvalid() {
+	configs := []*core.BoxConfig{
+		{Volumes: []string{"cache"}},
+		{Volumes: []string{"cache:relative"}},
+		{Volumes: []string{"cache:/cache:rx"}},
+		{Tmpfs: []string{"tmp"}},
+		{Devices: []string{"fuse"}},
+	}
+	for _, config := range configs {
+		s.Error(boxMounts(config, true, &docker.HostConfig{}), "%+v", config)
+	}
+}
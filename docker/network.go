@@ -0,0 +1,143 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+)
+
+func (b *DockerBox) getNetworkName() string {
+	if b.options.KeepServices {
+		return keptNetworkName(keptServicesProject(b.options))
+	}
+	return "wercker-network-" + b.options.PipelineID
+}
+
+// createNetwork makes the bridge network the box and its services share,
+// with --keep-services the network of an earlier run is reused
+func (b *DockerBox) createNetwork() error {
+	name := b.getNetworkName()
+	if b.options.KeepServices {
+		if _, err := b.client.NetworkInfo(name); err == nil {
+			b.logger.Debugln("Reusing network:", name)
+			b.network = name
+			return nil
+		}
+	}
+	b.logger.Debugln("Creating network:", name)
+	_, err := b.client.CreateNetwork(docker.CreateNetworkOptions{
+		Name:           name,
+		Driver:         "bridge",
+		CheckDuplicate: true,
+	})
+	if err != nil {
+		return err
+	}
+	b.network = name
+	return nil
+}
+
+// removeNetwork tears down the network if we made one, all the containers
+// on it need to be gone already. Kept services still need theirs.
+func (b *DockerBox) removeNetwork() error {
+	if b.network == "" || b.options.KeepServices {
+		return nil
+	}
+	b.logger.Debugln("Removing network:", b.network)
+	err := b.client.RemoveNetwork(b.network)
+	if err != nil {
+		return err
+	}
+	b.network = ""
+	return nil
+}
+
+// networkingConfig attaches a container to network under the given aliases
+func networkingConfig(network string, aliases []string) *docker.NetworkingConfig {
+	return &docker.NetworkingConfig{
+		EndpointsConfig: map[string]*docker.EndpointConfig{
+			network: {Aliases: aliases},
+		},
+	}
+}
+
+// serviceAliases are the hostnames a service can be reached by, the short
+// name of its ID plus its name if it has one
+func serviceAliases(shortName string, config *core.BoxConfig) []string {
+	aliases := []string{shortName}
+	if config != nil && config.Name != "" && config.Name != shortName {
+		aliases = append(aliases, config.Name)
+	}
+	return aliases
+}
+
+// linkEnv recreates the environment variables a legacy docker link used to
+// set (NGINX_PORT=tcp://..., NGINX_PORT_80_TCP_ADDR=...) so existing
+// pipelines keep working on the network
+func linkEnv(alias string, container *docker.Container, network string) []string {
+	if container.NetworkSettings == nil || container.Config == nil {
+		return nil
+	}
+	endpoint, ok := container.NetworkSettings.Networks[network]
+	if !ok || endpoint.IPAddress == "" {
+		return nil
+	}
+	ip := endpoint.IPAddress
+
+	prefix := strings.ToUpper(strings.Replace(alias, "-", "_", -1))
+	env := []string{fmt.Sprintf("%s_NAME=/%s", prefix, alias)}
+
+	ports := []string{}
+	for port := range container.Config.ExposedPorts {
+		ports = append(ports, string(port))
+	}
+	sort.Sort(byPortNumber(ports))
+
+	for i, p := range ports {
+		port := docker.Port(p)
+		url := fmt.Sprintf("%s://%s:%s", port.Proto(), ip, port.Port())
+		if i == 0 {
+			env = append(env, fmt.Sprintf("%s_PORT=%s", prefix, url))
+		}
+		portPrefix := fmt.Sprintf("%s_PORT_%s_%s", prefix, port.Port(), strings.ToUpper(port.Proto()))
+		env = append(env,
+			fmt.Sprintf("%s=%s", portPrefix, url),
+			fmt.Sprintf("%s_ADDR=%s", portPrefix, ip),
+			fmt.Sprintf("%s_PORT=%s", portPrefix, port.Port()),
+			fmt.Sprintf("%s_PROTO=%s", portPrefix, port.Proto()),
+		)
+	}
+	return env
+}
+
+// byPortNumber sorts "80/tcp" style ports numerically like docker does
+type byPortNumber []string
+
+func (p byPortNumber) Len() int      { return len(p) }
+func (p byPortNumber) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byPortNumber) Less(i, j int) bool {
+	var a, b int
+	fmt.Sscanf(p[i], "%d", &a)
+	fmt.Sscanf(p[j], "%d", &b)
+	if a == b {
+		return p[i] < p[j]
+	}
+	return a < b
+}
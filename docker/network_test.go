@@ -0,0 +1,70 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+)
+
+type NetworkSuite struct {
+	*util.TestSuite
+}
+
+func TestNetworkSuite(t *testing.T) {
+	suiteTester := &NetworkSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *NetworkSuite) TestServiceAliases() {
+	s.Equal([]string{"mongo"}, serviceAliases("mongo", &core.BoxConfig{ID: "mongo"}))
+	s.Equal([]string{"mongo"}, serviceAliases("mongo", &core.BoxConfig{ID: "mongo", Name: "mongo"}))
+	s.Equal([]string{"mongo", "db"}, serviceAliases("mongo", &core.BoxConfig{ID: "mongo", Name: "db"}))
+}
+
+func (s *NetworkSuite) TestLinkEnv() {
+	container := &docker.Container{
+		Config: &docker.Config{
+			ExposedPorts: map[docker.Port]struct{}{
+				"8080/tcp": {},
+				"443/tcp":  {},
+			},
+		},
+		NetworkSettings: &docker.NetworkSettings{
+			Networks: map[string]docker.ContainerNetwork{
+				"wercker-network-1": {IPAddress: "172.18.0.2"},
+			},
+		},
+	}
+
+	s.Equal([]string{
+		"MY_DB_NAME=/my-db",
+		"MY_DB_PORT=tcp://172.18.0.2:443",
+		"MY_DB_PORT_443_TCP=tcp://172.18.0.2:443",
+		"MY_DB_PORT_443_TCP_ADDR=172.18.0.2",
+		"MY_DB_PORT_443_TCP_PORT=443",
+		"MY_DB_PORT_443_TCP_PROTO=tcp",
+		"MY_DB_PORT_8080_TCP=tcp://172.18.0.2:8080",
+		"MY_DB_PORT_8080_TCP_ADDR=172.18.0.2",
+		"MY_DB_PORT_8080_TCP_PORT=8080",
+		"MY_DB_PORT_8080_TCP_PROTO=tcp",
+	}, linkEnv("my-db", container, "wercker-network-1"))
+
+	s.Empty(linkEnv("my-db", container, "some-other-network"))
+}
@@ -29,6 +29,10 @@ type DockerOptions struct {
 	DockerCertPath  string
 	DockerDNS       []string
 	DockerLocal     bool
+	Runtime         string
+	// Client is used instead of connecting to Runtime when set, the tests
+	// use it to run pipelines in a fake runtime
+	Client ContainerRuntime
 }
 
 func guessAndUpdateDockerOptions(opts *DockerOptions, e *util.Environment) {
@@ -40,6 +44,11 @@ func guessAndUpdateDockerOptions(opts *DockerOptions, e *util.Environment) {
 	// f := &util.Formatter{opts.GlobalOptions.ShowColors}
 	f := &util.Formatter{false}
 
+	if opts.Runtime == RuntimePodman {
+		guessPodmanSocket(opts, e)
+		return
+	}
+
 	// Check the unix socket, default on linux
 	// This will fail instantly so don't bother with the goroutine
 	if runtime.GOOS == "linux" {
@@ -96,6 +105,37 @@ func guessAndUpdateDockerOptions(opts *DockerOptions, e *util.Environment) {
 	logger.Println(f.Info("No Docker host found, falling back to default", opts.DockerHost))
 }
 
+// guessPodmanSocket checks the rootless socket for the current user first and
+// then the system-wide one
+func guessPodmanSocket(opts *DockerOptions, e *util.Environment) {
+	logger := util.RootLogger().WithField("Logger", "docker")
+	f := &util.Formatter{false}
+
+	sockets := []string{}
+	if runtimeDir := e.Get("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		sockets = append(sockets, "unix://"+filepath.Join(runtimeDir, "podman/podman.sock"))
+	}
+	sockets = append(sockets, "unix:///run/podman/podman.sock")
+
+	for _, socket := range sockets {
+		logger.Println(f.Info("No Podman host specified, checking", socket))
+		client, err := NewPodmanClient(&DockerOptions{
+			DockerHost: socket,
+		})
+		if err == nil {
+			_, err = client.Version()
+			if err == nil {
+				opts.DockerHost = socket
+				return
+			}
+		}
+	}
+
+	// Fall back to the system socket and let RequireDockerEndpoint complain
+	opts.DockerHost = sockets[len(sockets)-1]
+	logger.Println(f.Info("No Podman socket found, falling back to default", opts.DockerHost))
+}
+
 // NewDockerOptions constructor
 func NewDockerOptions(c util.Settings, e *util.Environment) (*DockerOptions, error) {
 	dockerHost, _ := c.String("docker-host")
@@ -103,6 +143,7 @@ func NewDockerOptions(c util.Settings, e *util.Environment) (*DockerOptions, err
 	dockerCertPath, _ := c.String("docker-cert-path")
 	dockerDNS, _ := c.StringSlice("docker-dns")
 	dockerLocal, _ := c.Bool("docker-local")
+	containerRuntime, _ := c.String("runtime")
 
 	speculativeOptions := &DockerOptions{
 		DockerHost:      dockerHost,
@@ -110,6 +151,7 @@ func NewDockerOptions(c util.Settings, e *util.Environment) (*DockerOptions, err
 		DockerCertPath:  dockerCertPath,
 		DockerDNS:       dockerDNS,
 		DockerLocal:     dockerLocal,
+		Runtime:         containerRuntime,
 	}
 
 	// We're going to try out a few settings and set DockerHost if
@@ -16,10 +16,12 @@ package dockerlocal
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/wercker/wercker/core"
 	"github.com/wercker/wercker/util"
+	"golang.org/x/net/context"
 )
 
 // DockerPipeline is our docker PipelineConfigurer and Pipeline impl
@@ -62,7 +64,16 @@ func NewDockerPipeline(name string, config *core.Config, options *core.PipelineO
 
 	afterStepsConfig := pipelineConfig.AfterSteps
 
-	box, err := NewDockerBox(boxConfig, options, dockerOptions)
+	var box core.Box
+	var err error
+	switch {
+	case isHost(boxConfig.ID):
+		box, err = NewHostBox(boxConfig, options)
+	case isSSH(boxConfig.ID):
+		box, err = NewSSHBox(boxConfig, options)
+	default:
+		box, err = NewDockerBox(boxConfig, options, dockerOptions)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -124,9 +135,51 @@ func NewDockerPipeline(name string, config *core.Config, options *core.PipelineO
 	return &DockerPipeline{BasePipeline: base, options: options, dockerOptions: dockerOptions}, nil
 }
 
+// ExportEnvironment to the session, and save it in the guest for
+// `wercker exec` to pick up
+func (p *DockerPipeline) ExportEnvironment(sessionCtx context.Context, sess *core.Session) error {
+	// Pick up what the steps before the checkpoint exported first, the
+	// pipeline's own environment wins
+	if core.Resumed(p.Box()) {
+		if err := sendHidden(sessionCtx, sess, fmt.Sprintf(`. "%s"`, pipelineEnvPath(p.options))); err != nil {
+			return err
+		}
+	}
+	if err := p.BasePipeline.ExportEnvironment(sessionCtx, sess); err != nil {
+		return err
+	}
+	return SaveEnvironment(sessionCtx, sess, p.options, p.Env())
+}
+
+// SaveEnvironment writes what the session has exported to the guest for
+// `wercker exec` and resumed pipelines to read back. The hidden part of env
+// is left out, the file ends up in committed images; whoever reads it has
+// to export that again from the host.
+func SaveEnvironment(sessionCtx context.Context, sess *core.Session, options *core.PipelineOptions, env *util.Environment) error {
+	save := fmt.Sprintf(`export -p > "%s"`, pipelineEnvPath(options))
+	if len(env.Hidden.Order) > 0 {
+		save = fmt.Sprintf("unset %s; %s", strings.Join(env.Hidden.Order, " "), save)
+	}
+	return sendHidden(sessionCtx, sess, fmt.Sprintf("(umask 077 && %s)", save))
+}
+
+// sendHidden runs command without logging it or its output
+func sendHidden(sessionCtx context.Context, sess *core.Session, command string) error {
+	sess.HideLogs()
+	defer sess.ShowLogs()
+	exit, _, err := sess.SendChecked(sessionCtx, command)
+	if err != nil {
+		return err
+	}
+	if exit != 0 {
+		return fmt.Errorf("Guest command failed: %s", command)
+	}
+	return nil
+}
+
 // CollectCache extracts the cache from the container to the cachedir
 func (p *DockerPipeline) CollectCache(containerID string) error {
-	client, err := NewDockerClient(p.dockerOptions)
+	client, err := NewContainerRuntime(p.dockerOptions)
 	if err != nil {
 		return err
 	}
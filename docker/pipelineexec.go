@@ -0,0 +1,143 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/docker/pkg/term"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+)
+
+const (
+	// pipelineLabel marks the main container of a pipeline with its id
+	pipelineLabel = "wercker.pipeline"
+
+	// pipelineEnvLabel tells `wercker exec` where the pipeline saved its
+	// environment
+	pipelineEnvLabel = "wercker.pipeline-env"
+)
+
+// pipelineEnvPath is where the pipeline saves its environment in the guest
+func pipelineEnvPath(options *core.PipelineOptions) string {
+	return options.GuestPath("env")
+}
+
+func pipelineLabels(options *core.PipelineOptions) map[string]string {
+	return map[string]string{
+		pipelineLabel:    options.PipelineID,
+		pipelineEnvLabel: pipelineEnvPath(options),
+	}
+}
+
+// FindPipelineContainer finds the container of the running pipeline with
+// pipelineID, or of the most recent one if pipelineID is empty
+func FindPipelineContainer(client ContainerRuntime, pipelineID string) (*docker.Container, error) {
+	filter := pipelineLabel
+	if pipelineID != "" {
+		filter = fmt.Sprintf("%s=%s", pipelineLabel, pipelineID)
+	}
+	containers, err := client.ListContainers(docker.ListContainersOptions{
+		Filters: map[string][]string{"label": {filter}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		if pipelineID != "" {
+			return nil, fmt.Errorf("No running pipeline with id %s", pipelineID)
+		}
+		return nil, fmt.Errorf("No running pipelines")
+	}
+
+	newest := containers[0]
+	for _, container := range containers[1:] {
+		if container.Created > newest.Created {
+			newest = container
+		}
+	}
+	return client.InspectContainer(newest.ID)
+}
+
+// pipelineExecCmd runs command, or the box's shell, in the source directory
+// with the environment the pipeline saved
+func pipelineExecCmd(container *docker.Container, command []string) []string {
+	shell := containerShell(container)
+	script := `cd "$WERCKER_SOURCE_DIR" 2>/dev/null; exec "$@"`
+	if container.Config != nil && container.Config.Labels[pipelineEnvLabel] != "" {
+		script = fmt.Sprintf(". %s 2>/dev/null; %s", shellQuote(container.Config.Labels[pipelineEnvLabel]), script)
+	}
+	if len(command) == 0 {
+		command = shell
+	}
+	cmd := append([]string{}, shell...)
+	cmd = append(cmd, "-c", script, "wercker-exec")
+	return append(cmd, command...)
+}
+
+// pipelineExecEnv is the hidden env, which the pipeline doesn't save, as
+// KEY=value pairs for the exec's environment so it doesn't show up in its
+// command line
+func pipelineExecEnv(hidden *util.Environment) []string {
+	if hidden == nil {
+		return nil
+	}
+	env := []string{}
+	for _, kv := range hidden.Ordered() {
+		env = append(env, fmt.Sprintf("%s=%s", kv[0], kv[1]))
+	}
+	return env
+}
+
+// ExecInPipeline runs command next to whatever the pipeline in container is
+// doing, interactively if we have a terminal and writing to stdout and
+// stderr if not, in which case a non-zero exit is an error
+func ExecInPipeline(client ContainerRuntime, container *docker.Container, command []string, hidden *util.Environment, stdout, stderr io.Writer) error {
+	cmd := pipelineExecCmd(container, command)
+	env := pipelineExecEnv(hidden)
+	if term.IsTerminal(os.Stdin.Fd()) {
+		return client.AttachInteractive(container.ID, cmd, env, nil)
+	}
+
+	exec, err := client.CreateExec(docker.CreateExecOptions{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+		Env:          env,
+		Container:    container.ID,
+	})
+	if err != nil {
+		return err
+	}
+	err = client.StartExec(exec.ID, docker.StartExecOptions{
+		OutputStream: stdout,
+		ErrorStream:  stderr,
+	})
+	if err != nil {
+		return err
+	}
+	inspect, err := client.InspectExec(exec.ID)
+	if err != nil {
+		return err
+	}
+	if inspect.ExitCode != 0 {
+		return fmt.Errorf("Command exited with %d", inspect.ExitCode)
+	}
+	return nil
+}
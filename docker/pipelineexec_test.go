@@ -0,0 +1,91 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/internal/fakeruntime/sandbox"
+	"github.com/wercker/wercker/util"
+)
+
+type PipelineExecSuite struct {
+	*util.TestSuite
+}
+
+func TestPipelineExecSuite(t *testing.T) {
+	suiteTester := &PipelineExecSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *PipelineExecSuite) TestExec() {
+	fake, err := sandbox.New(filepath.Join(s.WorkingDir(), "sandbox"))
+	s.Require().NoError(err)
+	defer fake.Close()
+
+	_, err = FindPipelineContainer(fake, "")
+	s.Error(err)
+
+	options := &core.PipelineOptions{
+		PipelineID: "running-build",
+		GuestRoot:  filepath.Join(fake.Root(), "pipeline"),
+	}
+	source := options.GuestPath("source")
+	s.Require().NoError(os.MkdirAll(source, 0755))
+	env := "export GREETING='hello there'\nexport WERCKER_SOURCE_DIR='" + source + "'\n"
+	s.Require().NoError(ioutil.WriteFile(pipelineEnvPath(options), []byte(env), 0600))
+
+	created, err := fake.CreateContainer(docker.CreateContainerOptions{
+		Name: "wercker-pipeline-running-build",
+		Config: &docker.Config{
+			Cmd:    []string{"/bin/sh"},
+			Labels: pipelineLabels(options),
+		},
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(fake.StartContainer(created.ID, nil))
+
+	_, err = FindPipelineContainer(fake, "another-build")
+	s.Error(err)
+	container, err := FindPipelineContainer(fake, "")
+	s.Require().NoError(err)
+	s.Equal(created.ID, container.ID)
+	container, err = FindPipelineContainer(fake, "running-build")
+	s.Require().NoError(err)
+	s.Equal(created.ID, container.ID)
+
+	var out bytes.Buffer
+	hidden := util.NewEnvironment("SECRET=sshh")
+	command := []string{"sh", "-c", `echo "$GREETING $SECRET"; pwd`}
+	s.NotContains(pipelineExecCmd(container, command), "SECRET=sshh", "the hidden env isn't on the command line")
+	s.Equal([]string{"SECRET=sshh"}, pipelineExecEnv(hidden))
+	err = ExecInPipeline(fake, container, command, hidden, &out, ioutil.Discard)
+	s.Require().NoError(err)
+	s.Equal("hello there sshh\n"+source+"\n", out.String())
+
+	var stdout, stderr bytes.Buffer
+	err = ExecInPipeline(fake, container, []string{"sh", "-c", "echo oops >&2; exit 3"}, nil, &stdout, &stderr)
+	s.Error(err)
+	s.Equal("oops\n", stderr.String())
+
+	s.Equal("/bin/sh", pipelineExecCmd(container, nil)[4], "no command runs the box's shell")
+}
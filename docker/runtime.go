@@ -0,0 +1,155 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/util"
+)
+
+const (
+	// RuntimeDocker talks to a Docker daemon
+	RuntimeDocker = "docker"
+	// RuntimePodman talks to a Podman API service over its Docker-compatible
+	// socket
+	RuntimePodman = "podman"
+)
+
+// ContainerRuntime is everything the runner needs from the thing that
+// actually runs our containers. The signatures follow go-dockerclient so
+// that any Docker-compatible API can be plugged in.
+type ContainerRuntime interface {
+	// Containers
+	CreateContainer(docker.CreateContainerOptions) (*docker.Container, error)
+	StartContainer(string, *docker.HostConfig) error
+	StopContainer(string, uint) error
+	RestartContainer(string, uint) error
+	RemoveContainer(docker.RemoveContainerOptions) error
+	WaitContainer(string) (int, error)
+	InspectContainer(string) (*docker.Container, error)
+	ListContainers(docker.ListContainersOptions) ([]docker.APIContainers, error)
+	AttachToContainer(docker.AttachToContainerOptions) error
+	CopyFromContainer(docker.CopyFromContainerOptions) error
+	UploadToContainer(string, docker.UploadToContainerOptions) error
+	CommitContainer(docker.CommitContainerOptions) (*docker.Image, error)
+	Logs(docker.LogsOptions) error
+
+	// Exec
+	CreateExec(docker.CreateExecOptions) (*docker.Exec, error)
+	StartExec(string, docker.StartExecOptions) error
+	InspectExec(string) (*docker.ExecInspect, error)
+
+	// Images
+	InspectImage(string) (*docker.Image, error)
+	ImageHistory(string) ([]docker.ImageHistory, error)
+	ListImages(docker.ListImagesOptions) ([]docker.APIImages, error)
+	PullImage(docker.PullImageOptions, docker.AuthConfiguration) error
+	PushImage(docker.PushImageOptions, docker.AuthConfiguration) error
+	TagImage(string, docker.TagImageOptions) error
+	RemoveImage(string) error
+	ExportImage(docker.ExportImageOptions) error
+	ExportImages(docker.ExportImagesOptions) error
+	LoadImage(docker.LoadImageOptions) error
+	BuildImage(docker.BuildImageOptions) error
+
+	// Networks
+	CreateNetwork(docker.CreateNetworkOptions) (*docker.Network, error)
+	NetworkInfo(string) (*docker.Network, error)
+	RemoveNetwork(string) error
+
+	// Daemon
+	Ping() error
+	Version() (*docker.Env, error)
+
+	// Our helpers on top of the above
+	RunAndAttach(string) error
+	AttachInteractive(string, []string, []string, []string) error
+	ExecOne(string, []string, io.Writer) error
+}
+
+// accessChecker is a runtime that knows how to ask a registry whether we
+// may read or write a repository
+type accessChecker interface {
+	CheckAccess(CheckAccessOptions) (bool, error)
+}
+
+// checkAccess asks the registry through client, runtimes that don't talk to
+// registries allow everything
+func checkAccess(client ContainerRuntime, opts CheckAccessOptions) (bool, error) {
+	if checker, ok := client.(accessChecker); ok {
+		return checker.CheckAccess(opts)
+	}
+	return true, nil
+}
+
+// NewContainerRuntime picks the runtime implementation based on
+// DockerOptions.Runtime
+func NewContainerRuntime(options *DockerOptions) (ContainerRuntime, error) {
+	if options.Client != nil {
+		return options.Client, nil
+	}
+	switch options.Runtime {
+	case "", RuntimeDocker:
+		client, err := NewDockerClient(options)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	case RuntimePodman:
+		client, err := NewPodmanClient(options)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+	return nil, fmt.Errorf("Unknown container runtime: %s", options.Runtime)
+}
+
+// PodmanClient talks to `podman system service`. Podman implements most of
+// the Docker API, this type covers the parts where it differs.
+type PodmanClient struct {
+	*DockerClient
+}
+
+// NewPodmanClient based on options and env
+func NewPodmanClient(options *DockerOptions) (*PodmanClient, error) {
+	client, err := NewDockerClient(options)
+	if err != nil {
+		return nil, err
+	}
+	client.logger = util.RootLogger().WithField("Logger", "Podman")
+	return &PodmanClient{DockerClient: client}, nil
+}
+
+// StartContainer starts a container. Podman only accepts a HostConfig when
+// the container is created, so any given here is dropped.
+func (c *PodmanClient) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	if hostConfig != nil {
+		c.logger.Debugln("Podman ignores HostConfig on start, pass it to CreateContainer:", id)
+	}
+	return c.Client.StartContainer(id, nil)
+}
+
+// CopyFromContainer uses the archive endpoint since Podman doesn't support
+// the legacy copy endpoint. The resulting tarball has the same layout.
+func (c *PodmanClient) CopyFromContainer(opts docker.CopyFromContainerOptions) error {
+	return c.DownloadFromContainer(opts.Container, docker.DownloadFromContainerOptions{
+		OutputStream: opts.OutputStream,
+		Path:         opts.Resource,
+	})
+}
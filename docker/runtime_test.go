@@ -0,0 +1,48 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/util"
+)
+
+type RuntimeSuite struct {
+	*util.TestSuite
+}
+
+func TestRuntimeSuite(t *testing.T) {
+	suiteTester := &RuntimeSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *RuntimeSuite) TestNewContainerRuntime() {
+	host := "unix:///tmp/wercker-runtime-test.sock"
+
+	dockerRuntime, err := NewContainerRuntime(&DockerOptions{DockerHost: host})
+	s.Require().NoError(err)
+	_, ok := dockerRuntime.(*DockerClient)
+	s.True(ok, "Expected the default runtime to be docker")
+
+	podmanRuntime, err := NewContainerRuntime(&DockerOptions{DockerHost: host, Runtime: RuntimePodman})
+	s.Require().NoError(err)
+	_, ok = podmanRuntime.(*PodmanClient)
+	s.True(ok, "Expected a podman runtime")
+
+	_, err = NewContainerRuntime(&DockerOptions{DockerHost: host, Runtime: "rkt"})
+	s.Error(err)
+}
@@ -0,0 +1,189 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+	"golang.org/x/net/context"
+)
+
+// runtimePath is copied from the build container to dest in the runtime box
+type runtimePath struct {
+	src  string
+	dest string
+}
+
+// parseRuntimePaths reads "src[:dest]" pairs, relative sources are relative
+// to sourcePath and without a dest a path ends up where it was
+func parseRuntimePaths(paths, sourcePath string) ([]runtimePath, error) {
+	parsed := []runtimePath{}
+	for _, entry := range util.SplitSpaceOrComma(paths) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		src := parts[0]
+		if !path.IsAbs(src) {
+			src = path.Join(sourcePath, src)
+		}
+		dest := src
+		if len(parts) == 2 {
+			dest = parts[1]
+		}
+		if !path.IsAbs(dest) {
+			return nil, fmt.Errorf("Invalid runtime path %q, the destination has to be absolute", entry)
+		}
+		parsed = append(parsed, runtimePath{src: path.Clean(src), dest: path.Clean(dest)})
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("runtime-box needs runtime-paths to copy into it")
+	}
+	return parsed, nil
+}
+
+// moveTar rewrites the tarball CopyFromContainer gives us for p, which is
+// rooted at the base name of p.src, so it unpacks to p.dest from /
+func moveTar(r io.Reader, w io.Writer, p runtimePath) error {
+	base := path.Base(p.src)
+	dest := strings.TrimPrefix(p.dest, "/")
+	rename := func(name string) string {
+		switch {
+		case name == base || name == base+"/":
+			return dest + strings.TrimPrefix(name, base)
+		case strings.HasPrefix(name, base+"/"):
+			return dest + name[len(base):]
+		}
+		return name
+	}
+
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		hdr.Name = rename(hdr.Name)
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = rename(hdr.Linkname)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// copyToRuntimeBox streams p from one container into another
+func copyToRuntimeBox(client ContainerRuntime, fromID, toID string, p runtimePath) error {
+	copied, copiedWriter := io.Pipe()
+	moved, movedWriter := io.Pipe()
+
+	go func() {
+		copiedWriter.CloseWithError(client.CopyFromContainer(docker.CopyFromContainerOptions{
+			Container:    fromID,
+			Resource:     p.src,
+			OutputStream: copiedWriter,
+		}))
+	}()
+	go func() {
+		err := moveTar(copied, movedWriter, p)
+		if err == nil {
+			// Whatever padding follows the end of the archive
+			_, err = io.Copy(ioutil.Discard, copied)
+		}
+		copied.CloseWithError(err)
+		movedWriter.CloseWithError(err)
+	}()
+
+	err := client.UploadToContainer(toID, docker.UploadToContainerOptions{
+		InputStream: moved,
+		Path:        "/",
+	})
+	moved.Close()
+	if err != nil {
+		return fmt.Errorf("Unable to copy %s to %s in the runtime box: %s", p.src, p.dest, err)
+	}
+	return nil
+}
+
+// commitRuntimeBox copies the runtime paths from the build container into a
+// fresh container of the runtime box and commits that one instead, so the
+// image doesn't carry the build tools along
+func (s *DockerPushStep) commitRuntimeBox(ctx context.Context, client ContainerRuntime, containerID string, commitOpts docker.CommitContainerOptions) (*docker.Image, error) {
+	paths, err := parseRuntimePaths(s.runtimePaths, s.options.SourcePath())
+	if err != nil {
+		return nil, err
+	}
+
+	config := &core.BoxConfig{ID: s.runtimeBox}
+	if s.runtimeAuth != nil {
+		config.Username = s.runtimeAuth.Username
+		config.Password = s.runtimeAuth.Password
+		config.Registry = s.runtimeAuth.Registry
+	}
+	box, err := NewDockerBox(config, s.options, s.dockerOptions)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := box.Fetch(ctx, s.runtimeEnv); err != nil {
+		return nil, err
+	}
+
+	// The pipeline ID keeps concurrent runs and leftovers of crashed ones
+	// from taking each other's name
+	runtime, err := client.CreateContainer(docker.CreateContainerOptions{
+		Name: fmt.Sprintf("wercker-runtime-%s-%s", s.options.PipelineID, s.SafeID()),
+		Config: &docker.Config{
+			Image:      box.Name,
+			Cmd:        s.cmd,
+			Entrypoint: s.entrypoint,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer client.RemoveContainer(docker.RemoveContainerOptions{
+		ID:            runtime.ID,
+		RemoveVolumes: true,
+		Force:         true,
+	})
+
+	for _, p := range paths {
+		s.logger.Debugln("Copying to runtime box:", p.src, p.dest)
+		if err := copyToRuntimeBox(client, containerID, runtime.ID, p); err != nil {
+			return nil, err
+		}
+	}
+
+	commitOpts.Container = runtime.ID
+	return client.CommitContainer(commitOpts)
+}
@@ -0,0 +1,84 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/internal/fakeruntime/sandbox"
+	"github.com/wercker/wercker/util"
+)
+
+type RuntimeBoxSuite struct {
+	*util.TestSuite
+}
+
+func TestRuntimeBoxSuite(t *testing.T) {
+	suiteTester := &RuntimeBoxSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *RuntimeBoxSuite) TestParseRuntimePaths() {
+	paths, err := parseRuntimePaths("bin/app:/usr/local/bin/app, /etc/app", "/pipeline/source")
+	s.Require().NoError(err)
+	s.Equal([]runtimePath{
+		{src: "/pipeline/source/bin/app", dest: "/usr/local/bin/app"},
+		{src: "/etc/app", dest: "/etc/app"},
+	}, paths)
+
+	_, err = parseRuntimePaths("bin/app:usr/bin/app", "/pipeline/source")
+	s.Error(err)
+
+	_, err = parseRuntimePaths("", "/pipeline/source")
+	s.Error(err)
+}
+
+func (s *RuntimeBoxSuite) TestCopyToRuntimeBox() {
+	fake, err := sandbox.New(filepath.Join(s.WorkingDir(), "sandbox"))
+	s.Require().NoError(err)
+	defer fake.Close()
+
+	build := filepath.Join(fake.Root(), "pipeline", "source")
+	s.Require().NoError(os.MkdirAll(filepath.Join(build, "bin", "assets"), 0755))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(build, "bin", "app"), []byte("binary"), 0755))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(build, "bin", "assets", "style.css"), []byte("css"), 0644))
+
+	from, err := fake.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{}})
+	s.Require().NoError(err)
+	to, err := fake.CreateContainer(docker.CreateContainerOptions{Config: &docker.Config{}})
+	s.Require().NoError(err)
+
+	paths, err := parseRuntimePaths("bin/app:/usr/local/bin/app bin/assets:/srv/static", build)
+	s.Require().NoError(err)
+	for _, p := range paths {
+		s.Require().NoError(copyToRuntimeBox(fake, from.ID, to.ID, p))
+	}
+
+	uploads := fake.UploadDir(to.ID)
+	content, err := ioutil.ReadFile(filepath.Join(uploads, "usr", "local", "bin", "app"))
+	s.NoError(err)
+	s.Equal("binary", string(content))
+	content, err = ioutil.ReadFile(filepath.Join(uploads, "srv", "static", "style.css"))
+	s.NoError(err)
+	s.Equal("css", string(content))
+
+	missing := runtimePath{src: filepath.Join(build, "missing"), dest: "/missing"}
+	s.Error(copyToRuntimeBox(fake, from.ID, to.ID, missing))
+}
@@ -15,8 +15,10 @@
 package dockerlocal
 
 import (
-	"bytes"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/fsouza/go-dockerclient"
@@ -45,7 +47,11 @@ func NewNilBuilder() *nilBuilder {
 // InternalServiceBox wraps a box as a service
 type InternalServiceBox struct {
 	*DockerBox
-	logger *util.LogEntry
+	logger    *util.LogEntry
+	aliases   []string
+	health    *healthcheck
+	dependsOn []string
+	logsDone  chan struct{}
 }
 
 // ExternalServiceBox wraps a box as a service
@@ -58,11 +64,20 @@ type ExternalServiceBox struct {
 // NewExternalServiceBox gives us an ExternalServiceBox from config
 func NewExternalServiceBox(boxConfig *core.BoxConfig, options *core.PipelineOptions, dockerOptions *DockerOptions, builder Builder) (*ExternalServiceBox, error) {
 	logger := util.RootLogger().WithField("Logger", "ExternalService")
+	health, err := newHealthcheck(boxConfig.Healthcheck)
+	if err != nil {
+		return nil, err
+	}
 	box := &DockerBox{options: options, dockerOptions: dockerOptions, config: boxConfig}
 	return &ExternalServiceBox{
-		InternalServiceBox: &InternalServiceBox{DockerBox: box, logger: logger},
-		externalConfig:     boxConfig,
-		builder:            builder,
+		InternalServiceBox: &InternalServiceBox{
+			DockerBox: box,
+			logger:    logger,
+			health:    health,
+			dependsOn: boxConfig.DependsOn,
+		},
+		externalConfig: boxConfig,
+		builder:        builder,
 	}, nil
 }
 
@@ -77,6 +92,8 @@ func (s *ExternalServiceBox) Fetch(ctx context.Context, env *util.Environment) (
 	box.image = image
 	s.DockerBox = box
 	s.ShortName = originalShortName
+	// The box we built has its own config, keep the names of the service
+	s.aliases = serviceAliases(originalShortName, s.externalConfig)
 	return image, err
 }
 
@@ -90,8 +107,22 @@ func NewServiceBox(config *core.BoxConfig, options *core.PipelineOptions, docker
 // NewServiceBox from a name and other references
 func NewInternalServiceBox(boxConfig *core.BoxConfig, options *core.PipelineOptions, dockerOptions *DockerOptions) (*InternalServiceBox, error) {
 	box, err := NewDockerBox(boxConfig, options, dockerOptions)
+	if err != nil {
+		return nil, err
+	}
+	health, err := newHealthcheck(boxConfig.Healthcheck)
+	if err != nil {
+		return nil, err
+	}
 	logger := util.RootLogger().WithField("Logger", "Service")
-	return &InternalServiceBox{DockerBox: box, logger: logger}, err
+	aliases := serviceAliases(box.ShortName, boxConfig)
+	return &InternalServiceBox{
+		DockerBox: box,
+		logger:    logger,
+		aliases:   aliases,
+		health:    health,
+		dependsOn: boxConfig.DependsOn,
+	}, nil
 }
 
 // TODO(mh) need to add to interface?
@@ -101,15 +132,105 @@ func (b *InternalServiceBox) getContainerName() string {
 	return strings.Replace(containerName, ":", "_", -1)
 }
 
+// keptContainer names and labels a service for --keep-services and gives
+// us the container from an earlier run if it can be reused
+func (b *InternalServiceBox) keptContainer(client ContainerRuntime, opts *docker.CreateContainerOptions) (*docker.Container, error) {
+	project := keptServicesProject(b.options)
+	opts.Name = keptServiceName(project, b.logName())
+
+	hash, err := serviceHash(b.image.ID, *opts)
+	if err != nil {
+		return nil, err
+	}
+	opts.Config.Labels = map[string]string{
+		keptServiceLabel: project,
+		serviceHashLabel: hash,
+	}
+	return reusableService(client, opts.Name, hash)
+}
+
+// Aliases are the hostnames the service gets on the pipeline network
+func (b *InternalServiceBox) Aliases() []string {
+	return b.aliases
+}
+
+// DependsOn lists the services that need to be ready before this one starts
+func (b *InternalServiceBox) DependsOn() []string {
+	return b.dependsOn
+}
+
+// logName is what we call the service in logs, its name if it has one
+func (b *InternalServiceBox) logName() string {
+	return b.aliases[len(b.aliases)-1]
+}
+
+func (b *InternalServiceBox) logStream() string {
+	return "service:" + b.logName()
+}
+
+// LogPath is where the service logs are written to on the host
+func (b *InternalServiceBox) LogPath() string {
+	return b.options.ServiceLogPath(b.logName())
+}
+
+// LogsDone is closed once LogPath has everything the service logged, that
+// is after it stopped. It is closed right away if the logs weren't followed.
+func (b *InternalServiceBox) LogsDone() <-chan struct{} {
+	if b.logsDone == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return b.logsDone
+}
+
+// streamLogs follows the service output until it exits, emitting it and
+// writing it to LogPath. A reused service only shows what it logs from now on.
+func (b *InternalServiceBox) streamLogs(e *core.NormalizedEmitter, client ContainerRuntime, reused bool) error {
+	logPath := b.LogPath()
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return err
+	}
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return err
+	}
+
+	tail := "all"
+	if reused {
+		tail = "0"
+	}
+	out := io.MultiWriter(logFile, &logsWriter{e: e, stream: b.logStream()})
+	b.logsDone = make(chan struct{})
+	go func() {
+		defer close(b.logsDone)
+		defer logFile.Close()
+		err := client.Logs(docker.LogsOptions{
+			Container:    b.container.ID,
+			Follow:       true,
+			Stdout:       true,
+			Stderr:       true,
+			OutputStream: out,
+			ErrorStream:  out,
+			RawTerminal:  false,
+			Tail:         tail,
+		})
+		if err != nil {
+			b.logger.WithField("Error", err).Warnln("Stopped following service logs", b.ShortName)
+		}
+	}()
+	return nil
+}
+
 // Run executes the service
-func (b *InternalServiceBox) Run(ctx context.Context, env *util.Environment, links []string) (*docker.Container, error) {
+func (b *InternalServiceBox) Run(ctx context.Context, env *util.Environment, network string) (*docker.Container, error) {
 	e, err := core.EmitterFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 	f := &util.Formatter{}
 
-	client, err := NewDockerClient(b.dockerOptions)
+	client, err := NewContainerRuntime(b.dockerOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -143,23 +264,49 @@ func (b *InternalServiceBox) Run(ctx context.Context, env *util.Environment, lin
 		cmdInfo = append(cmdInfo, origCmd...)
 	}
 
-	container, err := client.CreateContainer(
-		docker.CreateContainerOptions{
-			Name: b.getContainerName(),
-			Config: &docker.Config{
-				Image:           b.Name,
-				Cmd:             cmd,
-				Env:             myEnv,
-				NetworkDisabled: b.networkDisabled,
-				DNS:             b.dockerOptions.DockerDNS,
-				Entrypoint:      entrypoint,
-			},
-		})
-
+	hostConfig := &docker.HostConfig{
+		DNS:         b.dockerOptions.DockerDNS,
+		NetworkMode: network,
+	}
+	err = resourceLimits(b.config, hostConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = boxMounts(b.config, b.options.AllowPrivileged, hostConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	createOpts := docker.CreateContainerOptions{
+		Name: b.getContainerName(),
+		Config: &docker.Config{
+			Image:           b.Name,
+			Cmd:             cmd,
+			Env:             myEnv,
+			NetworkDisabled: b.networkDisabled,
+			DNS:             b.dockerOptions.DockerDNS,
+			Entrypoint:      entrypoint,
+		},
+		HostConfig:       hostConfig,
+		NetworkingConfig: networkingConfig(network, b.Aliases()),
+	}
+
+	reused := false
+	var container *docker.Container
+	if b.options.KeepServices {
+		container, err = b.keptContainer(client, &createOpts)
+		if err != nil {
+			return nil, err
+		}
+		reused = container != nil
+	}
+	if !reused {
+		container, err = client.CreateContainer(createOpts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	out := []string{}
 	for _, part := range cmdInfo {
 		if strings.Contains(part, " ") {
@@ -168,15 +315,23 @@ func (b *InternalServiceBox) Run(ctx context.Context, env *util.Environment, lin
 			out = append(out, part)
 		}
 	}
-	if b.options.Verbose {
-		b.logger.Println(f.Info(fmt.Sprintf("Starting service %s", b.ShortName), strings.Join(out, " ")))
+	if reused {
+		e.Emit(core.Logs, &core.LogsArgs{
+			Logs: fmt.Sprintf("Reusing service %s\n", b.ShortName),
+		})
+	} else {
+		if b.options.Verbose {
+			b.logger.Println(f.Info(fmt.Sprintf("Starting service %s", b.ShortName), strings.Join(out, " ")))
+		}
+		client.StartContainer(container.ID, nil)
 	}
-
-	client.StartContainer(container.ID, &docker.HostConfig{
-		DNS:   b.dockerOptions.DockerDNS,
-		Links: links,
-	})
 	b.container = container
+	b.network = network
+
+	err = b.streamLogs(e, client, reused)
+	if err != nil {
+		return nil, err
+	}
 
 	go func() {
 		status, err := client.WaitContainer(container.ID)
@@ -186,29 +341,12 @@ func (b *InternalServiceBox) Run(ctx context.Context, env *util.Environment, lin
 		b.logger.Debugln("Service container finished with status code:", status, container.ID)
 
 		if status != 0 {
-			var errstream bytes.Buffer
-			var outstream bytes.Buffer
-			// recv := make(chan string)
-			// outputStream := NewReceiver(recv)
-			opts := docker.LogsOptions{
-				Container:    container.ID,
-				Stdout:       true,
-				Stderr:       true,
-				ErrorStream:  &errstream,
-				OutputStream: &outstream,
-				RawTerminal:  false,
-			}
-			err = client.Logs(opts)
-			if err != nil {
-				b.logger.Panicln(err)
+			if inspected, err := client.InspectContainer(container.ID); err == nil && inspected.State.OOMKilled {
+				b.logger.Errorln("Service", b.ShortName, "ran out of memory, limit:", b.config.Memory)
 			}
 			e.Emit(core.Logs, &core.LogsArgs{
-				Stream: fmt.Sprintf("%s-stdout", b.Name),
-				Logs:   outstream.String(),
-			})
-			e.Emit(core.Logs, &core.LogsArgs{
-				Stream: fmt.Sprintf("%s-stderr", b.Name),
-				Logs:   errstream.String(),
+				Stream: b.logStream(),
+				Logs:   fmt.Sprintf("Service %s exited with status code: %d\n", b.ShortName, status),
 			})
 		}
 	}()
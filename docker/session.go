@@ -15,7 +15,10 @@
 package dockerlocal
 
 import (
+	"fmt"
 	"io"
+	"io/ioutil"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/wercker/wercker/core"
@@ -26,14 +29,14 @@ import (
 // DockerTransport for docker containers
 type DockerTransport struct {
 	options     *core.PipelineOptions
-	client      *DockerClient
+	client      ContainerRuntime
 	containerID string
 	logger      *util.LogEntry
 }
 
 // NewDockerTransport constructor
 func NewDockerTransport(options *core.PipelineOptions, dockerOptions *DockerOptions, containerID string) (core.Transport, error) {
-	client, err := NewDockerClient(dockerOptions)
+	client, err := NewContainerRuntime(dockerOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -60,8 +63,8 @@ func (t *DockerTransport) Attach(sessionCtx context.Context, stdin io.Reader, st
 		Logs:         false,
 		Success:      started,
 		InputStream:  stdin,
-		ErrorStream:  stdout,
-		OutputStream: stderr,
+		ErrorStream:  stderr,
+		OutputStream: stdout,
 		RawTerminal:  false,
 	}
 
@@ -86,3 +89,137 @@ func (t *DockerTransport) Attach(sessionCtx context.Context, stdin io.Reader, st
 	started <- struct{}{}
 	return transportCtx, nil
 }
+
+// containerTransport is what our transports have in common, steps that
+// need to get at the container directly use it
+type containerTransport interface {
+	ContainerID() string
+}
+
+// ContainerID is the container the transport talks to
+func (t *DockerTransport) ContainerID() string {
+	return t.containerID
+}
+
+// DockerExecTransport runs every command with its own docker exec instead
+// of typing into an attached shell, so each command gets its own exit code
+// and output streams. The environment, working directory, shell options and
+// functions are carried over between commands in a file in the container.
+type DockerExecTransport struct {
+	*DockerTransport
+	shell []string
+}
+
+// NewDockerExecTransport constructor
+func NewDockerExecTransport(options *core.PipelineOptions, dockerOptions *DockerOptions, containerID string) (core.Transport, error) {
+	client, err := NewContainerRuntime(dockerOptions)
+	if err != nil {
+		return nil, err
+	}
+	logger := util.RootLogger().WithField("Logger", "DockerExecTransport")
+	return &DockerExecTransport{
+		DockerTransport: &DockerTransport{options: options, client: client, containerID: containerID, logger: logger},
+	}, nil
+}
+
+// containerShell is the shell a container was started with, boxes with an
+// entrypoint get /bin/sh
+func containerShell(container *docker.Container) []string {
+	if container.Config != nil && len(container.Config.Entrypoint) == 0 && len(container.Config.Cmd) > 0 {
+		return container.Config.Cmd
+	}
+	return []string{"/bin/sh"}
+}
+
+// Attach doesn't attach anything, commands are run with RunCommand. The
+// returned context is closed when the container dies.
+func (t *DockerExecTransport) Attach(sessionCtx context.Context, stdin io.Reader, stdout, stderr io.Writer) (context.Context, error) {
+	container, err := t.client.InspectContainer(t.containerID)
+	if err != nil {
+		return nil, err
+	}
+	// Run commands with the same shell the box was started with
+	t.shell = containerShell(container)
+
+	transportCtx, cancel := context.WithCancel(sessionCtx)
+	go func() {
+		defer cancel()
+		status, err := t.client.WaitContainer(t.containerID)
+		if err != nil {
+			t.logger.Errorln("Error waiting", err)
+		}
+		t.logger.Debugln("Container finished with status code:", status, t.containerID)
+	}()
+	return transportCtx, nil
+}
+
+// execScript wraps command so it starts from the state the previous command
+// left behind in statePath and saves its own when it exits, however it
+// exits. The shell options go in a file of their own so they're restored
+// last, errexit would otherwise trip over anything in the state that can't
+// be restored. The shell's pid is kept in statePath-pid while it runs.
+func execScript(statePath, command string) string {
+	return fmt.Sprintf(`echo $$ > %[1]s-pid
+if [ -f %[1]s ]; then . %[1]s 2>/dev/null; . %[1]s-options; fi
+trap 'wercker_exit=$?; set +o > %[1]s-options; set +e; { export -p; declare -f 2>/dev/null; echo "cd \"$PWD\""; } > %[1]s; rm -f %[1]s-pid; exit $wercker_exit' EXIT
+%[2]s`, statePath, command)
+}
+
+// killScript kills the command started by execScript and everything it
+// started. It is stopped first so it can't start anything new meanwhile.
+func killScript(statePath string) string {
+	return fmt.Sprintf(`wercker_kill() { kill -STOP $1 2>/dev/null; for c in $(cat /proc/$1/task/*/children 2>/dev/null); do wercker_kill $c; done; kill -KILL $1 2>/dev/null; }
+if [ -f %[1]s-pid ]; then wercker_kill "$(cat %[1]s-pid)"; rm -f %[1]s-pid; fi`, statePath)
+}
+
+// execKillWait is how long a killed command gets to go away, the next one
+// starts anyway after that
+var execKillWait = 10 * time.Second
+
+// RunCommand runs command in a new exec and returns its exit code. When ctx
+// is done the command is killed before returning, so the next one never
+// runs next to it.
+func (t *DockerExecTransport) RunCommand(ctx context.Context, command string, stdout, stderr io.Writer) (int, error) {
+	statePath := fmt.Sprintf("/tmp/.wercker-session-%s", t.containerID)
+	cmd := append(t.shell[:len(t.shell):len(t.shell)], "-c", execScript(statePath, command))
+	exec, err := t.client.CreateExec(docker.CreateExecOptions{
+		Container:    t.containerID,
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	started := make(chan error, 1)
+	go func() {
+		started <- t.client.StartExec(exec.ID, docker.StartExecOptions{
+			OutputStream: stdout,
+			ErrorStream:  stderr,
+		})
+	}()
+	select {
+	case <-ctx.Done():
+		kill := append(t.shell[:len(t.shell):len(t.shell)], "-c", killScript(statePath))
+		if err := t.client.ExecOne(t.containerID, kill, ioutil.Discard); err != nil {
+			t.logger.WithField("Error", err).Warnln("Unable to kill command")
+		}
+		select {
+		case <-started:
+		case <-time.After(execKillWait):
+			t.logger.Warnln("Command still running after it was killed")
+		}
+		return -1, ctx.Err()
+	case err := <-started:
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	inspect, err := t.client.InspectExec(exec.ID)
+	if err != nil {
+		return -1, err
+	}
+	return inspect.ExitCode, nil
+}
@@ -0,0 +1,231 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+	"golang.org/x/net/context"
+)
+
+// shell starts command lines somewhere, over ssh or on this machine, for
+// the boxes that don't run in a container
+type shell interface {
+	command(tty bool, line string) *exec.Cmd
+}
+
+// runShell runs line to completion, the error includes whatever it printed
+// to stderr
+func runShell(sh shell, line string, stdin io.Reader, stdout io.Writer) error {
+	cmd := sh.command(false, line)
+	var stderr bytes.Buffer
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %s: %s", cmd.Args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// shellCopyFrom writes a tarball of p to w, laid out like the one
+// CopyFromContainer gives us
+func shellCopyFrom(sh shell, p string, w io.Writer) error {
+	return runShell(sh, fmt.Sprintf("tar -C %s -cf - %s", shellQuote(path.Dir(p)), shellQuote(path.Base(p))), nil, w)
+}
+
+// shellUpload copies what we would have mounted into a container to dest.
+// Only the symlinks we keep in hostPath are followed, and skip, our working
+// directory, is left out in case it lives in the project.
+func shellUpload(sh shell, hostPath, dest, skip string) error {
+	entries, err := ioutil.ReadDir(hostPath)
+	if err != nil {
+		return err
+	}
+	if abs, err := filepath.Abs(skip); err == nil {
+		skip = abs
+	}
+	if real, err := filepath.EvalSymlinks(skip); err == nil {
+		skip = real
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && entry.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		src, err := filepath.EvalSymlinks(filepath.Join(hostPath, entry.Name()))
+		if err != nil {
+			return err
+		}
+		if src, err = filepath.Abs(src); err != nil {
+			return err
+		}
+		args := []string{"-cf", "-", "-C", src}
+		if rel, err := filepath.Rel(src, skip); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+			args = append(args, "--exclude", "./"+rel)
+		}
+		args = append(args, ".")
+
+		tarCmd := exec.Command("tar", args...)
+		var tarErr bytes.Buffer
+		tarCmd.Stderr = &tarErr
+		out, err := tarCmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		if err := tarCmd.Start(); err != nil {
+			return err
+		}
+		target := shellQuote(path.Join(dest, entry.Name()))
+		err = runShell(sh, fmt.Sprintf("mkdir -p %[1]s && tar -xf - -C %[1]s", target), out, nil)
+		if waitErr := tarCmd.Wait(); waitErr != nil {
+			return fmt.Errorf("tar failed: %s: %s", waitErr, strings.TrimSpace(tarErr.String()))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shellInteractive opens a terminal in sh with the environment of step,
+// the environment goes through a file in dir so secrets stay out of the
+// process list
+func shellInteractive(sh shell, dir, cwd, command string, pipeline core.Pipeline, step core.Step) error {
+	env := []string{}
+	env = append(env, pipeline.Env().Export()...)
+	env = append(env, pipeline.Env().Hidden.Export()...)
+	env = append(env, step.Env().Export()...)
+	env = append(env, fmt.Sprintf("cd %s", shellQuote(cwd)))
+
+	// It holds the hidden env, so only we can read it and it's gone as soon
+	// as the shell has it
+	rc := path.Join(dir, "recover.sh")
+	if err := runShell(sh, fmt.Sprintf("umask 077 && cat > %s", shellQuote(rc)), strings.NewReader(strings.Join(env, "\n")+"\n"), nil); err != nil {
+		return err
+	}
+
+	cmd := sh.command(true, fmt.Sprintf(". %[1]s; rm -f %[1]s; clear; exec %[2]s", shellQuote(rc), command))
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// shellSession writes env, a list of K=V, to a file in dir that only we can
+// read and returns the command line that starts a session's shell in dir
+// with it. The file is removed once it's read, so the env stays out of the
+// process list and off the disk.
+func shellSession(sh shell, dir string, env []string, command string) (string, error) {
+	exports := []string{}
+	for _, e := range env {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		exports = append(exports, fmt.Sprintf("export %s=%s", parts[0], shellQuote(parts[1])))
+	}
+	envFile := path.Join(dir, "session-env.sh")
+	if err := runShell(sh, fmt.Sprintf("umask 077 && cat > %s", shellQuote(envFile)), strings.NewReader(strings.Join(exports, "\n")+"\n"), nil); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("cd %[1]s && . %[2]s && rm -f %[2]s && exec %[3]s", shellQuote(dir), shellQuote(envFile), command), nil
+}
+
+// shellQuote makes s safe to paste into a command line
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// copyFromContainer is CopyFromContainer that also knows how to get files
+// out of the boxes that aren't containers
+func copyFromContainer(client ContainerRuntime, opts docker.CopyFromContainerOptions) error {
+	switch {
+	case isSSH(opts.Container):
+		target, err := parseSSHTarget(opts.Container)
+		if err != nil {
+			return err
+		}
+		return shellCopyFrom(target, opts.Resource, opts.OutputStream)
+	case isHost(opts.Container):
+		return shellCopyFrom(localShell{}, opts.Resource, opts.OutputStream)
+	}
+	return client.CopyFromContainer(opts)
+}
+
+// ShellTransport is a shell started by a shell, the equivalent of attaching
+// to a container for the boxes that aren't one
+type ShellTransport struct {
+	sh          shell
+	line        string
+	containerID string
+	logger      *util.LogEntry
+}
+
+// NewShellTransport constructor, line starts the shell and containerID is
+// what the file collectors get handed
+func NewShellTransport(sh shell, line, containerID string) *ShellTransport {
+	logger := util.RootLogger().WithField("Logger", "ShellTransport")
+	return &ShellTransport{sh: sh, line: line, containerID: containerID, logger: logger}
+}
+
+// ContainerID is the id of the box the shell runs on
+func (t *ShellTransport) ContainerID() string {
+	return t.containerID
+}
+
+// Attach starts the shell, the context is done when it exits
+func (t *ShellTransport) Attach(sessionCtx context.Context, stdin io.Reader, stdout, stderr io.Writer) (context.Context, error) {
+	t.logger.Debugln("Starting shell on:", t.containerID)
+	cmd := t.sh.command(false, t.line)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	// Copy stdin ourselves, otherwise Wait won't return until the session
+	// stops sending
+	pipe, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	transportCtx, cancel := context.WithCancel(sessionCtx)
+	go func() {
+		io.Copy(pipe, stdin)
+		pipe.Close()
+	}()
+	go func() {
+		defer cancel()
+		err := cmd.Wait()
+		t.logger.Debugln("Shell exited:", err)
+	}()
+	go func() {
+		<-transportCtx.Done()
+		cmd.Process.Kill()
+	}()
+	return transportCtx, nil
+}
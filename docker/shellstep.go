@@ -56,6 +56,8 @@ func NewShellStep(stepConfig *core.StepConfig, options *core.PipelineOptions, do
 		Owner:       "wercker",
 		SafeID:      stepSafeID,
 		Version:     util.Version(),
+		Debug:       stepConfig.Debug,
+		Data:        stepConfig.Data,
 	})
 
 	return &ShellStep{
@@ -93,10 +95,9 @@ func (s *ShellStep) Fetch() (string, error) {
 func (s *ShellStep) Execute(ctx context.Context, sess *core.Session) (int, error) {
 	// cheating to get containerID
 	// TODO(termie): we should deal with this eventually
-	dt := sess.Transport().(*DockerTransport)
-	containerID := dt.containerID
+	containerID := sess.Transport().(containerTransport).ContainerID()
 
-	client, err := NewDockerClient(s.dockerOptions)
+	client, err := NewContainerRuntime(s.dockerOptions)
 	if err != nil {
 		return -1, err
 	}
@@ -106,7 +107,7 @@ func (s *ShellStep) Execute(ctx context.Context, sess *core.Session) (int, error
 	code = append(code, "clear")
 	code = append(code, s.Code)
 
-	err = client.AttachInteractive(containerID, s.Cmd, code)
+	err = client.AttachInteractive(containerID, s.Cmd, nil, code)
 	if err != nil {
 		return -1, err
 	}
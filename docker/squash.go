@@ -0,0 +1,588 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	opaqueWhiteout = ".wh..wh..opq"
+	// largestFiles is how many files the image report lists
+	largestFiles = 10
+)
+
+// savedConfig is the part of an image config we need when squashing
+type savedConfig struct {
+	RootFS  imageRootFS       `json:"rootfs"`
+	History []json.RawMessage `json:"history"`
+}
+
+// fileSize is a file added on top of the base image
+type fileSize struct {
+	Path string
+	Size int64
+}
+
+type bySize []fileSize
+
+func (s bySize) Len() int      { return len(s) }
+func (s bySize) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySize) Less(i, j int) bool {
+	if s[i].Size == s[j].Size {
+		return s[i].Path < s[j].Path
+	}
+	return s[i].Size > s[j].Size
+}
+
+// imageReport breaks down where the bytes of a committed image come from
+type imageReport struct {
+	Base     int64
+	Added    int64
+	Layers   int
+	Squashed int64
+	Largest  []fileSize
+}
+
+// String formats the report for the build log
+func (r *imageReport) String() string {
+	lines := []string{
+		fmt.Sprintf("Image size: %s base + %s added in %d layer(s)",
+			humanSize(r.Base), humanSize(r.Added), r.Layers),
+	}
+	if r.Squashed > 0 {
+		lines = append(lines, fmt.Sprintf("Squashed added layers to %s", humanSize(r.Squashed)))
+	}
+	if r.Squashed == 0 {
+		// Finding them means exporting the image, only squashing does
+		lines = append(lines, "Largest added files are only listed when squashing (--squash)")
+	} else if len(r.Largest) > 0 {
+		lines = append(lines, "Largest added files:")
+		for _, f := range r.Largest {
+			lines = append(lines, fmt.Sprintf("  %10s  /%s", humanSize(f.Size), f.Path))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func humanSize(size int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// mergedFile is the surviving copy of a path across the added layers
+type mergedFile struct {
+	layer  int
+	index  int
+	header *tar.Header
+}
+
+// mergedLayers is what the added layers look like applied on top of each
+// other, the way the daemon would apply them
+type mergedLayers struct {
+	files     map[string]*mergedFile
+	whiteouts map[string]bool
+	opaque    map[string]bool
+}
+
+func cleanLayerPath(name string) string {
+	return path.Clean(strings.TrimPrefix(name, "/"))
+}
+
+func isUnder(name, dir string) bool {
+	return dir == "." || strings.HasPrefix(name, dir+"/")
+}
+
+func (m *mergedLayers) removeUnder(dir string) {
+	for name := range m.files {
+		if isUnder(name, dir) {
+			delete(m.files, name)
+		}
+	}
+	for name := range m.whiteouts {
+		if isUnder(name, dir) {
+			delete(m.whiteouts, name)
+		}
+	}
+	for name := range m.opaque {
+		if isUnder(name, dir) {
+			delete(m.opaque, name)
+		}
+	}
+}
+
+func (m *mergedLayers) apply(layer, index int, hdr *tar.Header) {
+	name := cleanLayerPath(hdr.Name)
+	dir, base := path.Dir(name), path.Base(name)
+	switch {
+	case base == opaqueWhiteout:
+		m.removeUnder(dir)
+		m.opaque[dir] = true
+	case strings.HasPrefix(base, whiteoutPrefix):
+		target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+		delete(m.files, target)
+		m.removeUnder(target)
+		m.whiteouts[target] = true
+	default:
+		if existing, ok := m.files[name]; ok && existing.header.Typeflag == tar.TypeDir && hdr.Typeflag != tar.TypeDir {
+			m.removeUnder(name)
+		}
+		if m.whiteouts[name] {
+			// A whiteout and the file can't share a layer, if this is a
+			// directory an opaque marker still hides what was under it
+			delete(m.whiteouts, name)
+			if hdr.Typeflag == tar.TypeDir {
+				m.opaque[name] = true
+			}
+		}
+		m.files[name] = &mergedFile{layer: layer, index: index, header: hdr}
+	}
+}
+
+// mergeLayers applies the layer tarballs in order
+func mergeLayers(layers []string) (*mergedLayers, error) {
+	m := &mergedLayers{
+		files:     map[string]*mergedFile{},
+		whiteouts: map[string]bool{},
+		opaque:    map[string]bool{},
+	}
+	for i, layer := range layers {
+		err := eachLayerEntry(layer, func(index int, hdr *tar.Header, r io.Reader) error {
+			m.apply(i, index, hdr)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func eachLayerEntry(layer string, fn func(int, *tar.Header, io.Reader) error) error {
+	f, err := os.Open(layer)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for index := 0; ; index++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(index, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// largest lists the n biggest regular files
+func (m *mergedLayers) largest(n int) []fileSize {
+	files := []fileSize{}
+	for name, f := range m.files {
+		if f.header.Typeflag == tar.TypeReg || f.header.Typeflag == tar.TypeRegA {
+			files = append(files, fileSize{Path: name, Size: f.header.Size})
+		}
+	}
+	sort.Sort(bySize(files))
+	if len(files) > n {
+		files = files[:n]
+	}
+	return files
+}
+
+// write writes the merged layers out as a single layer tarball
+func (m *mergedLayers) write(w io.Writer, layers []string) error {
+	tw := tar.NewWriter(w)
+	for i, layer := range layers {
+		err := eachLayerEntry(layer, func(index int, hdr *tar.Header, r io.Reader) error {
+			f, ok := m.files[cleanLayerPath(hdr.Name)]
+			if !ok || f.layer != i || f.index != index {
+				return nil
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err := io.Copy(tw, r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	markers := []string{}
+	for name := range m.whiteouts {
+		markers = append(markers, path.Join(path.Dir(name), whiteoutPrefix+path.Base(name)))
+	}
+	for name := range m.opaque {
+		markers = append(markers, path.Join(name, opaqueWhiteout))
+	}
+	sort.Strings(markers)
+	for _, name := range markers {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// untar unpacks the output of docker save into dir
+func untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, cleanLayerPath(hdr.Name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeSymlink:
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			err = os.Symlink(hdr.Linkname, target)
+		case tar.TypeReg, tar.TypeRegA:
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			var f *os.File
+			f, err = os.Create(target)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// savedImages is the unpacked output of docker save
+type savedImages struct {
+	dir     string
+	entries []dockerManifestEntry
+}
+
+func exportImages(client ContainerRuntime, dir string, names ...string) (*savedImages, error) {
+	r, w := io.Pipe()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- untar(r, dir)
+		r.Close()
+	}()
+	err := client.ExportImages(docker.ExportImagesOptions{Names: names, OutputStream: w})
+	w.CloseWithError(err)
+	if untarErr := <-errs; err == nil {
+		err = untarErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	saved := &savedImages{dir: dir}
+	manifest, err := ioutil.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(manifest, &saved.entries); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// entry finds an image by its ID, the digest of its config. The config is
+// <id>.json in older saves and blobs/sha256/<id> in newer ones, so go by
+// content rather than by name.
+func (s *savedImages) entry(id string) (*dockerManifestEntry, error) {
+	for i := range s.entries {
+		b, err := ioutil.ReadFile(s.path(s.entries[i].Config))
+		if err != nil {
+			return nil, err
+		}
+		if digestOf(b) == id {
+			return &s.entries[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Image %s not found in export", id)
+}
+
+func (s *savedImages) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *savedImages) config(entry *dockerManifestEntry) (map[string]json.RawMessage, *savedConfig, error) {
+	b, err := ioutil.ReadFile(s.path(entry.Config))
+	if err != nil {
+		return nil, nil, err
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, nil, err
+	}
+	config := &savedConfig{}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, nil, err
+	}
+	return raw, config, nil
+}
+
+// historyReport sizes image by the layers it adds on top of base, going by
+// the image history so nothing has to be exported
+func historyReport(client ContainerRuntime, base, id string) (*imageReport, error) {
+	baseHistory, err := client.ImageHistory(base)
+	if err != nil {
+		return nil, err
+	}
+	history, err := client.ImageHistory(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) < len(baseHistory) {
+		return nil, fmt.Errorf("Image %s is not based on %s", id, base)
+	}
+	report := &imageReport{}
+	for _, layer := range baseHistory {
+		report.Base += layer.Size
+	}
+	// History is newest first
+	for _, layer := range history[:len(history)-len(baseHistory)] {
+		report.Added += layer.Size
+		report.Layers++
+	}
+	return report, nil
+}
+
+// commitReport reports on image, committed from a container of the base
+// image, and squashes everything above base into one layer if asked to.
+// Only squashing exports the images, which also finds the largest added
+// files. workDir is used for scratch space. The returned image is the one
+// to use from now on.
+func commitReport(client ContainerRuntime, workDir, base string, image *docker.Image, repoTag, message string, squash bool) (*docker.Image, *imageReport, error) {
+	report, err := historyReport(client, base, image.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !squash {
+		return image, report, nil
+	}
+
+	dir, err := ioutil.TempDir(workDir, "commit-report-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	saved, err := exportImages(client, dir, base, image.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	baseEntry, err := saved.entry(base)
+	if err != nil {
+		return nil, nil, err
+	}
+	imageEntry, err := saved.entry(image.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(imageEntry.Layers) < len(baseEntry.Layers) {
+		return nil, nil, fmt.Errorf("Image %s is not based on %s", image.ID, base)
+	}
+	baseLayers := imageEntry.Layers[:len(baseEntry.Layers)]
+	added := []string{}
+	for _, layer := range imageEntry.Layers[len(baseLayers):] {
+		added = append(added, saved.path(layer))
+	}
+
+	merged, err := mergeLayers(added)
+	if err != nil {
+		return nil, nil, err
+	}
+	report.Largest = merged.largest(largestFiles)
+
+	squashed := filepath.Join(dir, "squashed.tar")
+	diffID, size, err := writeMergedLayer(merged, added, squashed)
+	if err != nil {
+		return nil, nil, err
+	}
+	report.Squashed = size
+
+	configJSON, err := squashedConfig(saved, baseEntry, imageEntry, diffID, message)
+	if err != nil {
+		return nil, nil, err
+	}
+	configName := strings.TrimPrefix(digestOf(configJSON), "sha256:") + ".json"
+
+	layers := map[string]string{"squashed/layer.tar": squashed}
+	for _, layer := range baseLayers {
+		layers[layer] = saved.path(layer)
+	}
+	manifest, err := json.Marshal([]dockerManifestEntry{{
+		Config:   configName,
+		RepoTags: []string{repoTag},
+		Layers:   append(baseLayers[:len(baseLayers):len(baseLayers)], "squashed/layer.tar"),
+	}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(writeSaveArchive(w, layers, configName, configJSON, manifest))
+	}()
+	err = client.LoadImage(docker.LoadImageOptions{InputStream: r})
+	r.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	squashedImage, err := client.InspectImage(repoTag)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The unsquashed image lost its tag, don't leave it lying around
+	client.RemoveImage(image.ID)
+	return squashedImage, report, nil
+}
+
+// writeMergedLayer writes the merged layers to path and returns the diff ID
+// and size of the result
+func writeMergedLayer(merged *mergedLayers, layers []string, path string) (string, int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(f, hash)}
+	if err := merged.write(counter, layers); err != nil {
+		return "", 0, err
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), counter.n, nil
+}
+
+// squashedConfig is the config of image with everything above base replaced
+// by the single layer diffID. Fields we don't know about are kept as is.
+func squashedConfig(saved *savedImages, baseEntry, imageEntry *dockerManifestEntry, diffID, message string) ([]byte, error) {
+	raw, config, err := saved.config(imageEntry)
+	if err != nil {
+		return nil, err
+	}
+	_, baseConfig, err := saved.config(baseEntry)
+	if err != nil {
+		return nil, err
+	}
+	baseCount := len(baseEntry.Layers)
+	if len(config.RootFS.DiffIDs) < baseCount {
+		return nil, fmt.Errorf("Image config has %d layers, expected at least %d", len(config.RootFS.DiffIDs), baseCount)
+	}
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs[:baseCount:baseCount], diffID)
+	history, err := json.Marshal(imageHistory{
+		Created:   time.Now().UTC(),
+		CreatedBy: "wercker",
+		Comment:   message,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if raw["rootfs"], err = json.Marshal(config.RootFS); err != nil {
+		return nil, err
+	}
+	if raw["history"], err = json.Marshal(append(baseConfig.History, history)); err != nil {
+		return nil, err
+	}
+	return json.Marshal(raw)
+}
+
+// writeSaveArchive writes a tarball docker load understands, layers maps
+// names in the tarball to files on disk
+func writeSaveArchive(w io.Writer, layers map[string]string, configName string, config, manifest []byte) error {
+	names := []string{}
+	for name := range layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		if err := addFileToTar(tw, name, layers[name]); err != nil {
+			return err
+		}
+	}
+	// manifest.json goes last, same as docker save
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{configName, config}, {"manifest.json", manifest}} {
+		if err := tw.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.data)), ModTime: time.Now()}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func addFileToTar(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
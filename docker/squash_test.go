@@ -0,0 +1,229 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/internal/fakeruntime/sandbox"
+	"github.com/wercker/wercker/util"
+)
+
+type SquashSuite struct {
+	*util.TestSuite
+}
+
+func TestSquashSuite(t *testing.T) {
+	suiteTester := &SquashSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+type tarEntry struct {
+	name    string
+	content string
+}
+
+// makeTar makes a tarball, names ending in / are directories
+func makeTar(entries ...tarEntry) []byte {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		if strings.HasSuffix(e.name, "/") {
+			hdr = &tar.Header{Name: e.name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		tw.WriteHeader(hdr)
+		tw.Write([]byte(e.content))
+	}
+	tw.Close()
+	return b.Bytes()
+}
+
+func readTar(r io.Reader) map[string]string {
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return files
+		}
+		content, _ := ioutil.ReadAll(tr)
+		files[hdr.Name] = string(content)
+	}
+}
+
+func (s *SquashSuite) writeLayers(layers ...[]byte) []string {
+	paths := []string{}
+	for i, layer := range layers {
+		p := filepath.Join(s.WorkingDir(), fmt.Sprintf("layer%d.tar", i))
+		s.Require().NoError(ioutil.WriteFile(p, layer, 0644))
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+var (
+	squashFirst = makeTar(
+		tarEntry{"etc/", ""},
+		tarEntry{"etc/a", strings.Repeat("a", 100)},
+		tarEntry{"etc/b", "b"},
+		tarEntry{"tmp/", ""},
+		tarEntry{"tmp/big", strings.Repeat("x", 1000)},
+		tarEntry{"gone/", ""},
+		tarEntry{"gone/file", "file"},
+	)
+	squashSecond = makeTar(
+		tarEntry{"etc/.wh.a", ""},
+		tarEntry{"etc/b", strings.Repeat("b", 200)},
+		tarEntry{"tmp/.wh..wh..opq", ""},
+		tarEntry{"tmp/small", "small"},
+		tarEntry{".wh.gone", ""},
+		tarEntry{"gone/", ""},
+	)
+)
+
+func (s *SquashSuite) TestMergeLayers() {
+	layers := s.writeLayers(squashFirst, squashSecond)
+	merged, err := mergeLayers(layers)
+	s.Require().NoError(err)
+
+	s.Equal([]fileSize{
+		{Path: "etc/b", Size: 200},
+		{Path: "tmp/small", Size: 5},
+	}, merged.largest(10))
+	s.Equal(map[string]bool{"etc/a": true}, merged.whiteouts)
+	s.Equal(map[string]bool{"tmp": true, "gone": true}, merged.opaque)
+
+	var b bytes.Buffer
+	s.Require().NoError(merged.write(&b, layers))
+	s.Equal(map[string]string{
+		"etc/":              "",
+		"etc/b":             strings.Repeat("b", 200),
+		"tmp/":              "",
+		"tmp/small":         "small",
+		"gone/":             "",
+		"etc/.wh.a":         "",
+		"gone/.wh..wh..opq": "",
+		"tmp/.wh..wh..opq":  "",
+	}, readTar(&b))
+}
+
+// saveRuntime serves a canned history and docker save tarball and keeps
+// what was loaded
+type saveRuntime struct {
+	*sandbox.Runtime
+	history map[string][]docker.ImageHistory
+	save    []byte
+	saved   bool
+	loaded  []byte
+}
+
+func (r *saveRuntime) ImageHistory(name string) ([]docker.ImageHistory, error) {
+	return r.history[name], nil
+}
+
+func (r *saveRuntime) ExportImages(opts docker.ExportImagesOptions) error {
+	r.saved = true
+	_, err := opts.OutputStream.Write(r.save)
+	return err
+}
+
+func (r *saveRuntime) LoadImage(opts docker.LoadImageOptions) error {
+	b, err := ioutil.ReadAll(opts.InputStream)
+	r.loaded = b
+	return err
+}
+
+func (s *SquashSuite) TestCommitReport() {
+	fake, err := sandbox.New(filepath.Join(s.WorkingDir(), "sandbox"))
+	s.Require().NoError(err)
+	defer fake.Close()
+
+	baseLayer := makeTar(tarEntry{"bin/", ""}, tarEntry{"bin/sh", "sh"})
+	baseConfig := `{"rootfs":{"type":"layers","diff_ids":["sha256:base"]},"history":[{"created_by":"base"}]}`
+	imageConfig := `{"config":{"Cmd":["/app"]},"rootfs":{"type":"layers","diff_ids":["sha256:base","sha256:one","sha256:two"]},"history":[{"created_by":"base"},{},{}]}`
+	baseID := digestOf([]byte(baseConfig))
+	imageID := digestOf([]byte(imageConfig))
+	// The base is in the old save layout, the image in the new one
+	baseName := strings.TrimPrefix(baseID, "sha256:") + ".json"
+	imageName := "blobs/sha256/" + strings.TrimPrefix(imageID, "sha256:")
+	manifest := `[{"Config":"` + baseName + `","RepoTags":["box:latest"],"Layers":["base/layer.tar"]},` +
+		`{"Config":"` + imageName + `","RepoTags":null,"Layers":["base/layer.tar","one/layer.tar","two/layer.tar"]}]`
+	runtime := &saveRuntime{
+		Runtime: fake,
+		history: map[string][]docker.ImageHistory{
+			baseID:  {{ID: baseID, Size: 100}},
+			imageID: {{ID: imageID, Size: 20}, {Size: 10}, {ID: baseID, Size: 100}},
+		},
+		save: makeTar(
+			tarEntry{"base/layer.tar", string(baseLayer)},
+			tarEntry{"one/layer.tar", string(squashFirst)},
+			tarEntry{"two/layer.tar", string(squashSecond)},
+			tarEntry{baseName, baseConfig},
+			tarEntry{imageName, imageConfig},
+			tarEntry{"manifest.json", manifest},
+		),
+	}
+
+	image := &docker.Image{ID: imageID}
+	got, report, err := commitReport(runtime, s.WorkingDir(), baseID, image, "build:latest", "Build 1", false)
+	s.Require().NoError(err)
+	s.Equal(image, got)
+	s.False(runtime.saved)
+	s.Nil(runtime.loaded)
+	s.Equal(int64(100), report.Base)
+	s.Equal(int64(30), report.Added)
+	s.Equal(2, report.Layers)
+	s.Empty(report.Largest)
+	s.Contains(report.String(), "only listed when squashing")
+
+	_, report, err = commitReport(runtime, s.WorkingDir(), baseID, image, "build:latest", "Build 1", true)
+	s.Require().NoError(err)
+	s.True(runtime.saved)
+	s.NotZero(report.Squashed)
+	s.Equal("etc/b", report.Largest[0].Path)
+	s.Contains(report.String(), "Largest added files:")
+
+	loaded := readTar(bytes.NewReader(runtime.loaded))
+	var entries []dockerManifestEntry
+	s.Require().NoError(json.Unmarshal([]byte(loaded["manifest.json"]), &entries))
+	s.Require().Len(entries, 1)
+	s.Equal([]string{"build:latest"}, entries[0].RepoTags)
+	s.Equal([]string{"base/layer.tar", "squashed/layer.tar"}, entries[0].Layers)
+	s.Equal(string(baseLayer), loaded["base/layer.tar"])
+
+	configJSON := loaded[entries[0].Config]
+	s.Equal(strings.TrimPrefix(digestOf([]byte(configJSON)), "sha256:")+".json", entries[0].Config)
+	var config struct {
+		Config  imageRunConfig    `json:"config"`
+		RootFS  imageRootFS       `json:"rootfs"`
+		History []json.RawMessage `json:"history"`
+	}
+	s.Require().NoError(json.Unmarshal([]byte(configJSON), &config))
+	s.Equal([]string{"/app"}, config.Config.Cmd)
+	s.Equal([]string{"sha256:base", digestOf([]byte(loaded["squashed/layer.tar"]))}, config.RootFS.DiffIDs)
+	s.Len(config.History, 2)
+	s.Contains(readTar(strings.NewReader(loaded["squashed/layer.tar"])), "etc/.wh.a")
+}
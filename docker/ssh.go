@@ -0,0 +1,245 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+	"golang.org/x/net/context"
+)
+
+// sshCommand is the ssh client we shell out to, tests swap it for a fake
+var sshCommand = "ssh"
+
+// defaultSSHDir is where builds go when the box id doesn't name a directory
+const defaultSSHDir = "/tmp/wercker"
+
+// sshTarget is a machine and directory we can reach over ssh, described by
+// a box id like ssh://user@host:2222/srv/wercker?identity=~/.ssh/id_rsa
+type sshTarget struct {
+	id       string
+	user     string
+	host     string
+	port     string
+	dir      string
+	identity string
+}
+
+// isSSH tells us if a box id or container id points at an ssh target
+func isSSH(id string) bool {
+	return strings.HasPrefix(id, "ssh://")
+}
+
+func parseSSHTarget(id string) (*sshTarget, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ssh" || u.Host == "" {
+		return nil, fmt.Errorf("Not an ssh box: %s", id)
+	}
+
+	t := &sshTarget{host: u.Host, dir: path.Clean(u.Path)}
+	if host, port, err := net.SplitHostPort(u.Host); err == nil {
+		t.host = host
+		t.port = port
+	}
+	if u.User != nil {
+		t.user = u.User.Username()
+	}
+	if t.dir == "." || t.dir == "/" {
+		t.dir = defaultSSHDir
+	}
+	if identity := u.Query().Get("identity"); identity != "" {
+		t.identity = util.ExpandHomePath(identity, os.Getenv("HOME"))
+	}
+
+	// The id doubles as the container id, it keeps the query so the file
+	// collectors parsing it again still use the identity
+	t.id = u.String()
+	return t, nil
+}
+
+// command builds an ssh invocation running remote, a shell command line
+func (t *sshTarget) command(tty bool, remote string) *exec.Cmd {
+	args := []string{"-o", "BatchMode=yes"}
+	if tty {
+		args = append(args, "-t")
+	} else {
+		args = append(args, "-T")
+	}
+	if t.port != "" {
+		args = append(args, "-p", t.port)
+	}
+	if t.identity != "" {
+		args = append(args, "-i", t.identity)
+	}
+	dest := t.host
+	if t.user != "" {
+		dest = t.user + "@" + t.host
+	}
+	args = append(args, dest, "--", remote)
+	return exec.Command(sshCommand, args...)
+}
+
+// SSHBox runs the pipeline in a directory on a machine we can ssh to
+// instead of in a container. Each run gets its own directory under the one
+// in the box id, the pipeline roots are moved there.
+type SSHBox struct {
+	Name     string
+	target   *sshTarget
+	config   *core.BoxConfig
+	options  *core.PipelineOptions
+	services []core.ServiceBox
+	root     string
+	cmd      string
+	env      []string
+	logger   *util.LogEntry
+}
+
+// NewSSHBox from an ssh:// box id
+func NewSSHBox(boxConfig *core.BoxConfig, options *core.PipelineOptions) (*SSHBox, error) {
+	target, err := parseSSHTarget(boxConfig.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := boxConfig.Cmd
+	if cmd == "" {
+		cmd = "/bin/bash"
+	}
+
+	// Steps find their way around through the options, so point them at
+	// the remote directory
+	root := path.Join(target.dir, options.PipelineID)
+	options.GuestRoot = path.Join(root, "pipeline")
+	options.MntRoot = path.Join(root, "mnt")
+	options.ReportRoot = path.Join(root, "report")
+	if options.DirectMount {
+		options.MntRoot = options.GuestRoot
+	}
+
+	logger := util.RootLogger().WithFields(util.LogFields{
+		"Logger": "SSHBox",
+		"Name":   target.id,
+	})
+
+	return &SSHBox{
+		Name:    target.id,
+		target:  target,
+		config:  boxConfig,
+		options: options,
+		root:    root,
+		cmd:     cmd,
+		logger:  logger,
+	}, nil
+}
+
+// GetName gets the box name
+func (b *SSHBox) GetName() string {
+	return b.Name
+}
+
+// GetTag is always empty, there is no image
+func (b *SSHBox) GetTag() string {
+	return ""
+}
+
+// AddService needed by this Box
+func (b *SSHBox) AddService(service core.ServiceBox) {
+	b.services = append(b.services, service)
+}
+
+// Services returns the service boxes attached to this box
+func (b *SSHBox) Services() []core.ServiceBox {
+	return b.services
+}
+
+// Fetch makes sure we can reach the host and creates the build directory
+func (b *SSHBox) Fetch(ctx context.Context, env *util.Environment) (*docker.Image, error) {
+	b.logger.Debugln("Checking ssh box:", b.Name)
+	if err := runShell(b.target, fmt.Sprintf("mkdir -p %s", shellQuote(b.root)), nil, nil); err != nil {
+		return nil, err
+	}
+	return &docker.Image{ID: b.Name}, nil
+}
+
+// Run uploads the source and cache, there is nothing to start. The
+// "container" it returns is the box id.
+func (b *SSHBox) Run(ctx context.Context, env *util.Environment) (*docker.Container, error) {
+	if len(b.services) > 0 {
+		return nil, fmt.Errorf("Services are not supported on ssh boxes")
+	}
+	b.env = dockerEnv(b.config.Env, env)
+	if err := shellUpload(b.target, b.options.HostPath(), b.options.MntRoot, b.options.WorkingDir); err != nil {
+		return nil, err
+	}
+	return &docker.Container{ID: b.target.id, Name: b.Name}, nil
+}
+
+// Restart gives us the same "container", the shell is per session anyway
+func (b *SSHBox) Restart() (*docker.Container, error) {
+	return &docker.Container{ID: b.target.id, Name: b.Name}, nil
+}
+
+// Stop does nothing, sessions end when their ssh connection does
+func (b *SSHBox) Stop() {
+	b.logger.Debugln("Nothing to stop on ssh box:", b.Name)
+}
+
+// Clean removes the build directory from the remote host
+func (b *SSHBox) Clean() error {
+	return runShell(b.target, fmt.Sprintf("rm -rf %s", shellQuote(b.root)), nil, nil)
+}
+
+// Commit can't make an image out of a directory
+func (b *SSHBox) Commit(ctx context.Context, name, tag, message string) (*docker.Image, error) {
+	return nil, fmt.Errorf("Can't commit an ssh box: %s", b.Name)
+}
+
+// OOMKilled is never true, we don't limit the remote shell
+func (b *SSHBox) OOMKilled() bool {
+	return false
+}
+
+// RecoverInteractive opens a terminal on the host with the environment of
+// the failed step
+func (b *SSHBox) RecoverInteractive(cwd string, pipeline core.Pipeline, step core.Step) error {
+	return b.AttachInteractive(cwd, pipeline, step)
+}
+
+// AttachInteractive opens a terminal on the host with the environment of
+// step
+func (b *SSHBox) AttachInteractive(cwd string, pipeline core.Pipeline, step core.Step) error {
+	return shellInteractive(b.target, b.root, cwd, b.cmd, pipeline, step)
+}
+
+// Transport is the shell the session runs in
+func (b *SSHBox) Transport(containerID string) (core.Transport, error) {
+	line, err := shellSession(b.target, b.root, b.env, b.cmd)
+	if err != nil {
+		return nil, err
+	}
+	return NewShellTransport(b.target, line, b.target.id), nil
+}
@@ -0,0 +1,202 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package dockerlocal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+	"golang.org/x/net/context"
+)
+
+// fakeSSH stands in for the ssh client, it skips past the options and
+// destination and runs the command locally. Identities it was given are
+// written to the identities file next to it.
+const fakeSSH = `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --) shift; break;;
+    -i) echo "$2" >> "$(dirname "$0")/identities"; shift 2;;
+    -o|-p) shift 2;;
+    *) shift;;
+  esac
+done
+exec sh -c "$1"
+`
+
+type SSHSuite struct {
+	*util.TestSuite
+	tmp    string
+	target string
+	fake   bool
+}
+
+func TestSSHSuite(t *testing.T) {
+	suiteTester := &SSHSuite{TestSuite: &util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+// SetupTest uses the fake ssh unless $WERCKER_TEST_SSH names a real host,
+// like ssh://localhost, to run against
+func (s *SSHSuite) SetupTest() {
+	tmp, err := ioutil.TempDir("", "ssh-test")
+	s.Require().NoError(err)
+	s.tmp = tmp
+
+	s.target = os.Getenv("WERCKER_TEST_SSH")
+	if s.target == "" {
+		fake := filepath.Join(tmp, "ssh")
+		s.Require().NoError(ioutil.WriteFile(fake, []byte(fakeSSH), 0755))
+		sshCommand = fake
+		s.target = "ssh://localhost"
+		s.fake = true
+	}
+	s.target = strings.TrimRight(s.target, "/") + filepath.Join(tmp, "remote")
+}
+
+func (s *SSHSuite) TearDownTest() {
+	sshCommand = "ssh"
+	os.RemoveAll(s.tmp)
+}
+
+func (s *SSHSuite) TestParseTarget() {
+	t, err := parseSSHTarget("ssh://deploy@build.example.com:2222/srv/wercker/?identity=/keys/id_rsa")
+	s.Require().NoError(err)
+	s.Equal("ssh://deploy@build.example.com:2222/srv/wercker/?identity=/keys/id_rsa", t.id)
+	s.Equal("deploy", t.user)
+	s.Equal("build.example.com", t.host)
+	s.Equal("2222", t.port)
+	s.Equal("/srv/wercker", t.dir)
+	s.Equal("/keys/id_rsa", t.identity)
+	s.Equal([]string{
+		"ssh", "-o", "BatchMode=yes", "-T", "-p", "2222", "-i", "/keys/id_rsa",
+		"deploy@build.example.com", "--", "true",
+	}, t.command(false, "true").Args)
+
+	t, err = parseSSHTarget("ssh://localhost")
+	s.Require().NoError(err)
+	s.Equal(defaultSSHDir, t.dir)
+	s.Equal([]string{"ssh", "-o", "BatchMode=yes", "-t", "localhost", "--", "true"}, t.command(true, "true").Args)
+
+	_, err = parseSSHTarget("wercker/base")
+	s.Error(err)
+}
+
+func (s *SSHSuite) TestShellQuote() {
+	s.Equal(`'it'\''s here'`, shellQuote("it's here"))
+}
+
+func (s *SSHSuite) TestShellSession() {
+	dir := s.WorkingDir()
+	line, err := shellSession(localShell{}, dir, []string{"SECRET=it's me"}, `sh -c 'echo "$SECRET"'`)
+	s.Require().NoError(err)
+	s.NotContains(line, "it's me", "the env stays off the command line")
+
+	var out bytes.Buffer
+	s.Require().NoError(runShell(localShell{}, line, nil, &out))
+	s.Equal("it's me\n", out.String())
+	_, err = os.Stat(filepath.Join(dir, "session-env.sh"))
+	s.True(os.IsNotExist(err), "the env file is gone once read")
+}
+
+func (s *SSHSuite) TestLocalShellEnv() {
+	os.Setenv("WERCKER_TEST_LEAK", "sshh")
+	defer os.Unsetenv("WERCKER_TEST_LEAK")
+
+	var out bytes.Buffer
+	s.Require().NoError(runShell(localShell{}, `echo "${WERCKER_TEST_LEAK:-unset} $HOME"`, nil, &out))
+	s.Equal("unset "+os.Getenv("HOME")+"\n", out.String())
+}
+
+func (s *SSHSuite) TestPipeline() {
+	options := core.EmptyPipelineOptions()
+	options.WorkingDir = filepath.Join(s.tmp, "local")
+	options.PipelineID = "ssh-pipeline"
+	options.NoResponseTimeout = 100
+	options.CommandTimeout = 100
+	source := options.HostPath("source")
+	s.Require().NoError(os.MkdirAll(source, 0755))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(source, "hello.txt"), []byte("hello\n"), 0644))
+
+	// The fake can check the identity is used everywhere
+	target := s.target
+	identities := filepath.Join(s.tmp, "identities")
+	if s.fake {
+		target += "?identity=" + filepath.Join(s.tmp, "id_test")
+	}
+
+	box, err := NewSSHBox(&core.BoxConfig{ID: target, Cmd: "/bin/sh", Env: map[string]string{"greeting": "hi there"}}, options)
+	s.Require().NoError(err)
+	s.Equal(filepath.Join(s.tmp, "remote", "ssh-pipeline", "pipeline"), options.GuestRoot)
+
+	ctx := core.NewEmitterContext(context.Background())
+	_, err = box.Fetch(ctx, util.NewEnvironment())
+	s.Require().NoError(err)
+	container, err := box.Run(ctx, util.NewEnvironment())
+	s.Require().NoError(err)
+	s.Equal(target, container.ID)
+
+	transport, err := box.Transport(container.ID)
+	s.Require().NoError(err)
+	sess := core.NewSession(options, transport)
+	sessionCtx, err := sess.Attach(ctx)
+	s.Require().NoError(err)
+
+	exit, recv, err := sess.SendChecked(sessionCtx, `cat "`+options.MntPath("source", "hello.txt")+`"`, `echo "$GREETING"`)
+	s.Require().NoError(err)
+	s.Equal(0, exit)
+	s.Equal([]string{"hello\n", "hi there\n"}, recv)
+
+	exit, _, err = sess.SendChecked(sessionCtx, "false")
+	s.NoError(err)
+	s.Equal(1, exit)
+
+	var archive, file bytes.Buffer
+	os.Remove(identities)
+	err = copyFromContainer(nil, docker.CopyFromContainerOptions{
+		Container:    container.ID,
+		Resource:     options.MntPath("source", "hello.txt"),
+		OutputStream: &archive,
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(util.UntarOne("hello.txt", &file, ioutil.NopCloser(&archive)))
+	s.Equal("hello\n", file.String())
+	if s.fake {
+		used, err := ioutil.ReadFile(identities)
+		s.Require().NoError(err, "the copy has to use the identity")
+		s.Equal(filepath.Join(s.tmp, "id_test")+"\n", string(used))
+	}
+
+	s.NoError(box.Clean())
+	_, err = os.Stat(filepath.Join(s.tmp, "remote", "ssh-pipeline"))
+	s.True(os.IsNotExist(err))
+}
+
+func (s *SSHSuite) TestServicesUnsupported() {
+	options := core.EmptyPipelineOptions()
+	box, err := NewSSHBox(&core.BoxConfig{ID: s.target}, options)
+	s.Require().NoError(err)
+	box.AddService(&ExternalServiceBox{})
+	_, err = box.Run(context.Background(), util.NewEnvironment())
+	s.Error(err)
+}
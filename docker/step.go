@@ -81,7 +81,7 @@ func NewDockerStep(config *core.StepConfig, options *core.PipelineOptions, docke
 
 // CollectFile gets an individual file from the container
 func (s *DockerStep) CollectFile(containerID, path, name string, dst io.Writer) error {
-	client, err := NewDockerClient(s.dockerOptions)
+	client, err := NewContainerRuntime(s.dockerOptions)
 	if err != nil {
 		return err
 	}
@@ -99,7 +99,7 @@ func (s *DockerStep) CollectFile(containerID, path, name string, dst io.Writer)
 		errs <- util.UntarOne(name, dst, pipeReader)
 	}()
 
-	if err = client.CopyFromContainer(opts); err != nil {
+	if err = copyFromContainer(client, opts); err != nil {
 		s.logger.Debug("Probably expected error:", err)
 		return util.ErrEmptyTarball
 	}
@@ -58,6 +58,8 @@ func NewStoreContainerStep(stepConfig *core.StepConfig, options *core.PipelineOp
 		Owner:       "wercker",
 		SafeID:      stepSafeID,
 		Version:     util.Version(),
+		Debug:       stepConfig.Debug,
+		Data:        stepConfig.Data,
 	})
 
 	return &StoreContainerStep{
@@ -106,6 +108,24 @@ func (s *StoreContainerStep) DockerMessage() string {
 	return message
 }
 
+// report prints the size breakdown of the committed image and squashes it
+// if asked to. Failing to report is only fatal when squashing.
+func (s *StoreContainerStep) report(e *core.NormalizedEmitter, client ContainerRuntime, base string, image *docker.Image, squash bool) (*docker.Image, error) {
+	repoTag := fmt.Sprintf("%s:%s", s.DockerRepo(), s.DockerTag())
+	squashed, report, err := commitReport(client, s.options.BuildPath(), base, image, repoTag, s.DockerMessage(), squash)
+	if err != nil {
+		if squash {
+			return nil, err
+		}
+		s.logger.WithField("Error", err).Warnln("Unable to report on image size")
+		return image, nil
+	}
+	e.Emit(core.Logs, &core.LogsArgs{
+		Logs: report.String(),
+	})
+	return squashed, nil
+}
+
 // Execute does the actual export and upload of the container
 func (s *StoreContainerStep) Execute(ctx context.Context, sess *core.Session) (int, error) {
 	e, err := core.EmitterFromContext(ctx)
@@ -113,14 +133,13 @@ func (s *StoreContainerStep) Execute(ctx context.Context, sess *core.Session) (i
 		return -1, err
 	}
 	// TODO(termie): could probably re-use the tansport's client
-	client, err := NewDockerClient(s.dockerOptions)
+	client, err := NewContainerRuntime(s.dockerOptions)
 	if err != nil {
 		return -1, err
 	}
 	// This is clearly only relevant to docker so we're going to dig into the
 	// transport internals a little bit to get the container ID
-	dt := sess.Transport().(*DockerTransport)
-	containerID := dt.containerID
+	containerID := sess.Transport().(containerTransport).ContainerID()
 
 	repoName := s.DockerRepo()
 	tag := s.DockerTag()
@@ -140,6 +159,16 @@ func (s *StoreContainerStep) Execute(ctx context.Context, sess *core.Session) (i
 	}
 	s.logger.WithField("Image", i).Debug("Commit completed")
 
+	squash := s.options.ShouldSquash || s.data["squash"] == "true"
+	container, err := client.InspectContainer(containerID)
+	if err != nil {
+		return -1, err
+	}
+	i, err = s.report(e, client, container.Image, i, squash)
+	if err != nil {
+		return -1, err
+	}
+
 	e.Emit(core.Logs, &core.LogsArgs{
 		Logs: "Exporting container\n",
 	})
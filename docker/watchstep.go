@@ -68,6 +68,8 @@ func NewWatchStep(stepConfig *core.StepConfig, options *core.PipelineOptions, do
 		Owner:       "wercker",
 		SafeID:      stepSafeID,
 		Version:     util.Version(),
+		Debug:       stepConfig.Debug,
+		Data:        stepConfig.Data,
 	})
 
 	return &WatchStep{
@@ -180,7 +182,7 @@ func (s *WatchStep) watch(root string) (*fsnotify.Watcher, error) {
 // killProcesses sends a signal to all the processes on the machine except
 // for PID 1, somewhat naive but seems to work
 func (s *WatchStep) killProcesses(containerID string, signal string) error {
-	client, err := NewDockerClient(s.dockerOptions)
+	client, err := NewContainerRuntime(s.dockerOptions)
 	if err != nil {
 		return err
 	}
@@ -207,10 +209,11 @@ func (s *WatchStep) Execute(ctx context.Context, sess *core.Session) (int, error
 	go func() {
 		for {
 			select {
-			case line := <-sess.Recv():
+			case out := <-sess.Recv():
 				e.Emit(core.Logs, &core.LogsArgs{
 					// Hidden: sess.logsHidden,
-					Logs: line,
+					Stream: out.Stream,
+					Logs:   out.Logs,
 				})
 			// We need to make sure we stop eating the stdout from the container
 			// promiscuously when we finish out step
@@ -222,8 +225,7 @@ func (s *WatchStep) Execute(ctx context.Context, sess *core.Session) (int, error
 
 	// cheating to get containerID
 	// TODO(termie): we should deal with this eventually
-	dt := sess.Transport().(*DockerTransport)
-	containerID := dt.containerID
+	containerID := sess.Transport().(containerTransport).ContainerID()
 
 	// Set up a signal handler to end our step.
 	finishedStep := make(chan struct{})
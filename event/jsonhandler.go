@@ -0,0 +1,264 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wercker/wercker/core"
+)
+
+// NewJSONEventHandler writes the events to target, which is a path or
+// fd:N for a file descriptor the caller opened for us. Stdout already has
+// the build log, so it can't be used.
+func NewJSONEventHandler(target string) (*JSONEventHandler, error) {
+	if target == "-" || target == "fd:1" {
+		return nil, fmt.Errorf("Can't write events to stdout, it has the build log: use a file or fd:N")
+	}
+	if strings.HasPrefix(target, "fd:") {
+		fd, err := strconv.Atoi(strings.TrimPrefix(target, "fd:"))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("Invalid file descriptor: %s", target)
+		}
+		f := os.NewFile(uintptr(fd), target)
+		return newJSONEventHandler(f, f), nil
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return newJSONEventHandler(f, f), nil
+}
+
+func newJSONEventHandler(w io.Writer, closer io.Closer) *JSONEventHandler {
+	return &JSONEventHandler{
+		encoder:   json.NewEncoder(w),
+		closer:    closer,
+		startStep: make(map[string]time.Time),
+	}
+}
+
+// A JSONEventHandler writes every event as a line of JSON so other tools
+// can follow a build as it happens
+type JSONEventHandler struct {
+	lock       sync.Mutex
+	encoder    *json.Encoder
+	closer     io.Closer
+	startStep  map[string]time.Time
+	startBuild time.Time
+}
+
+// ListenTo will add eventhandlers to e.
+func (h *JSONEventHandler) ListenTo(e *core.NormalizedEmitter) {
+	e.AddListener(core.BuildStarted, h.BuildStarted)
+	e.AddListener(core.BuildStepsAdded, h.BuildStepsAdded)
+	e.AddListener(core.BuildStepStarted, h.BuildStepStarted)
+	e.AddListener(core.Logs, h.Logs)
+	e.AddListener(core.BuildStepFinished, h.BuildStepFinished)
+	e.AddListener(core.BuildFinished, h.BuildFinished)
+	e.AddListener(core.FullPipelineFinished, h.FullPipelineFinished)
+}
+
+// BuildStarted responds to the BuildStarted event.
+func (h *JSONEventHandler) BuildStarted(args *core.BuildStartedArgs) {
+	h.lock.Lock()
+	h.startBuild = time.Now()
+	h.lock.Unlock()
+	h.write("buildStarted", args.Options, &JSONEvent{})
+}
+
+// BuildStepsAdded responds to the BuildStepsAdded event.
+func (h *JSONEventHandler) BuildStepsAdded(args *core.BuildStepsAddedArgs) {
+	e := &JSONEvent{}
+	for _, step := range args.Steps {
+		e.Steps = append(e.Steps, newJSONStep(step))
+	}
+	if args.StoreStep != nil {
+		e.StoreStep = newJSONStep(args.StoreStep)
+	}
+	for _, step := range args.AfterSteps {
+		e.AfterSteps = append(e.AfterSteps, newJSONStep(step))
+	}
+	h.write("buildStepsAdded", args.Options, e)
+}
+
+// BuildStepStarted responds to the BuildStepStarted event.
+func (h *JSONEventHandler) BuildStepStarted(args *core.BuildStepStartedArgs) {
+	h.lock.Lock()
+	h.startStep[args.Step.SafeID()] = time.Now()
+	h.lock.Unlock()
+	h.write("buildStepStarted", args.Options, &JSONEvent{
+		Step:  newJSONStep(args.Step),
+		Order: args.Order,
+	})
+}
+
+// Logs responds to the Logs event, hidden logs are left out since they
+// can hold secrets
+func (h *JSONEventHandler) Logs(args *core.LogsArgs) {
+	if args.Hidden {
+		return
+	}
+	e := &JSONEvent{
+		Order:  args.Order,
+		Stream: args.Stream,
+		Logs:   args.Logs,
+	}
+	if args.Step != nil {
+		e.Step = newJSONStep(args.Step)
+	}
+	h.write("logs", args.Options, e)
+}
+
+// BuildStepFinished responds to the BuildStepFinished event.
+func (h *JSONEventHandler) BuildStepFinished(args *core.BuildStepFinishedArgs) {
+	h.lock.Lock()
+	duration := since(h.startStep[args.Step.SafeID()])
+	delete(h.startStep, args.Step.SafeID())
+	h.lock.Unlock()
+	h.write("buildStepFinished", args.Options, &JSONEvent{
+		Step:        newJSONStep(args.Step),
+		Order:       args.Order,
+		Duration:    &duration,
+		Success:     &args.Successful,
+		Message:     args.Message,
+		ArtifactURL: args.ArtifactURL,
+		PackageURL:  args.PackageURL,
+		Skipped:     args.Skipped,
+	})
+}
+
+// BuildFinished responds to the BuildFinished event.
+func (h *JSONEventHandler) BuildFinished(args *core.BuildFinishedArgs) {
+	h.lock.Lock()
+	duration := since(h.startBuild)
+	h.lock.Unlock()
+	h.write("buildFinished", args.Options, &JSONEvent{
+		Duration: &duration,
+		Result:   args.Result,
+	})
+}
+
+// FullPipelineFinished responds to the FullPipelineFinished event.
+func (h *JSONEventHandler) FullPipelineFinished(args *core.FullPipelineFinishedArgs) {
+	h.lock.Lock()
+	duration := since(h.startBuild)
+	h.lock.Unlock()
+	success := args.MainSuccessful && (!args.RanAfterSteps || args.AfterStepSuccessful)
+	h.write("fullPipelineFinished", args.Options, &JSONEvent{
+		Duration:            &duration,
+		Success:             &success,
+		MainSuccessful:      &args.MainSuccessful,
+		RanAfterSteps:       &args.RanAfterSteps,
+		AfterStepSuccessful: &args.AfterStepSuccessful,
+	})
+}
+
+// since is the milliseconds since begin, 0 if we never saw it start
+func since(begin time.Time) int64 {
+	if begin.IsZero() {
+		return 0
+	}
+	return int64(time.Since(begin) / time.Millisecond)
+}
+
+// Close closes the file the events go to, later events are dropped
+func (h *JSONEventHandler) Close() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	closer := h.closer
+	h.encoder = nil
+	h.closer = nil
+	if closer == nil {
+		return nil
+	}
+	return closer.Close()
+}
+
+func (h *JSONEventHandler) write(name string, options *core.PipelineOptions, e *JSONEvent) {
+	e.Event = name
+	e.Timestamp = time.Now().Format(time.RFC3339Nano)
+	if options != nil {
+		e.BuildID = options.BuildID
+		e.DeployID = options.DeployID
+		e.PipelineName = options.Pipeline
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.encoder == nil {
+		return
+	}
+	// There's nobody to tell when writing fails, the build goes on
+	h.encoder.Encode(e)
+}
+
+func newJSONStep(step core.Step) *JSONStep {
+	return &JSONStep{
+		ID:          step.ID(),
+		Name:        step.Name(),
+		DisplayName: step.DisplayName(),
+		Owner:       step.Owner(),
+		Version:     step.Version(),
+		SafeID:      step.SafeID(),
+	}
+}
+
+// JSONStep describes a step in a JSONEvent
+type JSONStep struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Version     string `json:"version,omitempty"`
+	SafeID      string `json:"safeId,omitempty"`
+}
+
+// JSONEvent is a single line written by the JSONEventHandler, durations
+// are in milliseconds
+type JSONEvent struct {
+	Event        string `json:"event"`
+	Timestamp    string `json:"timestamp"`
+	BuildID      string `json:"buildId,omitempty"`
+	DeployID     string `json:"deployId,omitempty"`
+	PipelineName string `json:"pipelineName,omitempty"`
+
+	Step       *JSONStep   `json:"step,omitempty"`
+	Order      int         `json:"order,omitempty"`
+	Steps      []*JSONStep `json:"steps,omitempty"`
+	StoreStep  *JSONStep   `json:"storeStep,omitempty"`
+	AfterSteps []*JSONStep `json:"afterSteps,omitempty"`
+
+	Stream string `json:"stream,omitempty"`
+	Logs   string `json:"logs,omitempty"`
+
+	Duration    *int64 `json:"duration,omitempty"`
+	Success     *bool  `json:"success,omitempty"`
+	Message     string `json:"message,omitempty"`
+	ArtifactURL string `json:"artifactUrl,omitempty"`
+	PackageURL  string `json:"packageUrl,omitempty"`
+	Skipped     bool   `json:"skipped,omitempty"`
+	Result      string `json:"result,omitempty"`
+
+	MainSuccessful      *bool `json:"mainSuccessful,omitempty"`
+	RanAfterSteps       *bool `json:"ranAfterSteps,omitempty"`
+	AfterStepSuccessful *bool `json:"afterStepSuccessful,omitempty"`
+}
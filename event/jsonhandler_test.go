@@ -0,0 +1,110 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package event
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+)
+
+type JSONEventSuite struct {
+	*util.TestSuite
+}
+
+func TestJSONEventSuite(t *testing.T) {
+	suiteTester := &JSONEventSuite{&util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *JSONEventSuite) TestEvents() {
+	options := core.EmptyPipelineOptions()
+	options.BuildID = "build-1"
+	step, err := core.NewStep(&core.StepConfig{ID: "script", Name: "one", Data: map[string]string{"code": "true"}}, options)
+	s.Require().NoError(err)
+
+	out := &bytes.Buffer{}
+	e := core.NewNormalizedEmitter()
+	newJSONEventHandler(out, nil).ListenTo(e)
+
+	e.Emit(core.BuildStarted, &core.BuildStartedArgs{Options: options})
+	e.Emit(core.BuildStepStarted, &core.BuildStepStartedArgs{Step: step, Order: 3})
+	e.Emit(core.Logs, &core.LogsArgs{Logs: "hello\n"})
+	e.Emit(core.Logs, &core.LogsArgs{Logs: "export SECRET=x\n", Hidden: true})
+	e.Emit(core.BuildStepFinished, &core.BuildStepFinishedArgs{Successful: false, Message: "boom"})
+	e.Emit(core.FullPipelineFinished, &core.FullPipelineFinishedArgs{MainSuccessful: true})
+
+	events := []*JSONEvent{}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		event := &JSONEvent{}
+		s.Require().NoError(json.Unmarshal(scanner.Bytes(), event))
+		events = append(events, event)
+	}
+	s.Require().Len(events, 5, "hidden logs are left out")
+
+	s.Equal("buildStarted", events[0].Event)
+	s.Equal("build-1", events[0].BuildID)
+
+	s.Equal("buildStepStarted", events[1].Event)
+	s.Equal("one", events[1].Step.DisplayName)
+	s.Equal(3, events[1].Order)
+
+	s.Equal("logs", events[2].Event)
+	s.Equal("hello\n", events[2].Logs)
+	s.Equal("stdout", events[2].Stream)
+	s.Equal("one", events[2].Step.DisplayName)
+
+	s.Equal("buildStepFinished", events[3].Event)
+	s.Equal("one", events[3].Step.DisplayName)
+	s.Require().NotNil(events[3].Success)
+	s.False(*events[3].Success)
+	s.Equal("boom", events[3].Message)
+	s.NotNil(events[3].Duration)
+
+	s.Equal("fullPipelineFinished", events[4].Event)
+	s.Require().NotNil(events[4].Success)
+	s.True(*events[4].Success)
+}
+
+func (s *JSONEventSuite) TestBadTarget() {
+	_, err := NewJSONEventHandler("fd:nope")
+	s.Error(err)
+	_, err = NewJSONEventHandler("-")
+	s.Error(err, "stdout has the build log")
+}
+
+func (s *JSONEventSuite) TestClose() {
+	path := filepath.Join(s.WorkingDir(), "events.json")
+	h, err := NewJSONEventHandler(path)
+	s.Require().NoError(err)
+	e := core.NewNormalizedEmitter()
+	h.ListenTo(e)
+
+	e.Emit(core.BuildStarted, &core.BuildStartedArgs{Options: core.EmptyPipelineOptions()})
+	s.Require().NoError(h.Close())
+	e.Emit(core.FullPipelineFinished, &core.FullPipelineFinishedArgs{MainSuccessful: true})
+
+	b, err := ioutil.ReadFile(path)
+	s.Require().NoError(err)
+	s.Equal(1, bytes.Count(b, []byte("\n")), "nothing is written after closing")
+}
@@ -15,6 +15,8 @@
 package event
 
 import (
+	"strings"
+
 	log "github.com/Sirupsen/logrus"
 	"github.com/wercker/reporter-client"
 	"github.com/wercker/wercker/core"
@@ -33,13 +35,15 @@ func NewLiteralLogHandler(options *core.PipelineOptions) (*LiteralLogHandler, er
 		logger.Level = log.InfoLevel
 	}
 
-	return &LiteralLogHandler{l: logger, options: options}, nil
+	formatter := &util.Formatter{ShowColors: options.GlobalOptions.ShowColors}
+	return &LiteralLogHandler{l: logger, formatter: formatter, options: options}, nil
 }
 
 // A LiteralLogHandler logs all events using Logrus.
 type LiteralLogHandler struct {
-	l       *util.Logger
-	options *core.PipelineOptions
+	l         *util.Logger
+	formatter *util.Formatter
+	options   *core.PipelineOptions
 }
 
 // Logs will handle the Logs event.
@@ -57,6 +61,8 @@ func (h *LiteralLogHandler) Logs(args *core.LogsArgs) {
 			"Hidden": args.Hidden,
 			"Stream": args.Stream,
 		}).Printf("%s %6s %q", shown, args.Stream, args.Logs)
+	} else if h.shouldPrintLog(args) && args.Stream == "stderr" {
+		h.l.Print(h.formatter.Stderr(args.Logs))
 	} else if h.shouldPrintLog(args) {
 		h.l.Print(args.Logs)
 	}
@@ -67,11 +73,21 @@ func (h *LiteralLogHandler) shouldPrintLog(args *core.LogsArgs) bool {
 		return false
 	}
 
+	// Quiet only shows what went wrong
+	if h.options.Quiet {
+		return args.Stream == "stderr"
+	}
+
 	// Do not show stdin stream is verbose is false
 	if args.Stream == "stdin" && !h.options.Verbose {
 		return false
 	}
 
+	// Service logs would drown out the steps, they're shown when a step fails
+	if strings.HasPrefix(args.Stream, "service:") && !h.options.Verbose {
+		return false
+	}
+
 	return true
 }
 
@@ -16,6 +16,7 @@ package event
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/wercker/reporter-client"
 	"github.com/wercker/wercker/core"
@@ -160,6 +161,10 @@ func (h *ReportHandler) Logs(args *core.LogsArgs) {
 	if args.Step == nil {
 		return
 	}
+	// Service logs are stored as artifacts, not as step output
+	if strings.HasPrefix(args.Stream, "service:") {
+		return
+	}
 
 	w, err := h.getStepOutputWriter(args)
 	if err != nil {
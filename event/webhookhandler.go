@@ -0,0 +1,369 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package event
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/pborman/uuid"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+)
+
+const (
+	webhookBuildStarted         = "buildStarted"
+	webhookBuildStepFinished    = "buildStepFinished"
+	webhookFullPipelineFinished = "fullPipelineFinished"
+)
+
+var (
+	// webhookEvents are the events a Webhook can ask for
+	webhookEvents = []string{webhookBuildStarted, webhookBuildStepFinished, webhookFullPipelineFinished}
+
+	// webhookAttempts is how often we try to deliver a payload, waiting
+	// webhookBackoff, then twice that and so on in between
+	webhookAttempts = 3
+	webhookBackoff  = time.Second
+	webhookTimeout  = 10 * time.Second
+
+	// webhookQueueSize is how many payloads can wait to be delivered before
+	// we drop new ones, webhookFinalWait is how long the end of the build
+	// waits for the queue to empty
+	webhookQueueSize = 100
+	webhookFinalWait = 30 * time.Second
+
+	// webhookFuncs are available to templates, json quotes a value so it
+	// can go into a JSON body as is
+	webhookFuncs = template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+)
+
+// Webhook is a URL we POST payloads about the build to
+type Webhook struct {
+	URL         string
+	Secret      string
+	Events      []string
+	Template    *template.Template
+	ContentType string
+}
+
+// NewWebhook checks the url and events and parses the template, if there is
+// one it renders the payload instead of sending it as JSON. Templates have
+// to quote values themselves, with the json function for a JSON body.
+// contentType defaults to application/json.
+func NewWebhook(rawURL, secret string, events []string, tmpl, contentType string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Webhook URL must be http or https: %s", rawURL)
+	}
+	for _, event := range events {
+		if !util.ContainsString(webhookEvents, event) {
+			return nil, fmt.Errorf("Unknown webhook event %s, pick from %v", event, webhookEvents)
+		}
+	}
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	hook := &Webhook{URL: rawURL, Secret: secret, Events: events, ContentType: contentType}
+	if tmpl != "" {
+		hook.Template, err = template.New(rawURL).Funcs(webhookFuncs).Parse(tmpl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hook, nil
+}
+
+// wants is whether the hook should get event, no events means all of them
+func (w *Webhook) wants(event string) bool {
+	return len(w.Events) == 0 || util.ContainsString(w.Events, event)
+}
+
+// sign is the signature of body we put in the X-Wercker-Signature header
+func (w *Webhook) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewWebhookHandler will create a new WebhookHandler.
+func NewWebhookHandler(hooks []*Webhook) *WebhookHandler {
+	h := &WebhookHandler{
+		client:    &http.Client{Timeout: webhookTimeout},
+		hooks:     hooks,
+		queue:     make(chan *webhookDelivery, webhookQueueSize),
+		startStep: make(map[string]time.Time),
+		logger:    util.RootLogger().WithField("Logger", "Webhook"),
+	}
+	go h.deliverAll()
+	return h
+}
+
+// A WebhookHandler POSTs build notifications to webhooks. Payloads are
+// delivered in order in the background, the final one is waited for up to
+// webhookFinalWait.
+type WebhookHandler struct {
+	lock       sync.Mutex
+	client     *http.Client
+	hooks      []*Webhook
+	queue      chan *webhookDelivery
+	pending    sync.WaitGroup
+	started    *WebhookPayload
+	startStep  map[string]time.Time
+	startBuild time.Time
+	failedStep string
+	failedMsg  string
+	logger     *util.LogEntry
+}
+
+type webhookDelivery struct {
+	hook    *Webhook
+	payload *WebhookPayload
+}
+
+// ListenTo will add eventhandlers to e.
+func (h *WebhookHandler) ListenTo(e *core.NormalizedEmitter) {
+	e.AddListener(core.BuildStarted, h.BuildStarted)
+	e.AddListener(core.BuildStepStarted, h.BuildStepStarted)
+	e.AddListener(core.BuildStepFinished, h.BuildStepFinished)
+	e.AddListener(core.FullPipelineFinished, h.FullPipelineFinished)
+}
+
+// AddWebhooks adds hooks we only learn about once the build is running,
+// like the ones in wercker.yml. They still hear that the build started.
+func (h *WebhookHandler) AddWebhooks(hooks ...*Webhook) {
+	h.lock.Lock()
+	h.hooks = append(h.hooks, hooks...)
+	started := h.started
+	h.lock.Unlock()
+	if started != nil {
+		h.send(hooks, started)
+	}
+}
+
+// BuildStarted responds to the BuildStarted event.
+func (h *WebhookHandler) BuildStarted(args *core.BuildStartedArgs) {
+	p := newWebhookPayload(webhookBuildStarted, args.Options)
+	h.lock.Lock()
+	h.startBuild = time.Now()
+	h.started = p
+	hooks := h.hooks
+	h.lock.Unlock()
+	h.send(hooks, p)
+}
+
+// BuildStepStarted responds to the BuildStepStarted event.
+func (h *WebhookHandler) BuildStepStarted(args *core.BuildStepStartedArgs) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.startStep[args.Step.SafeID()] = time.Now()
+}
+
+// BuildStepFinished responds to the BuildStepFinished event.
+func (h *WebhookHandler) BuildStepFinished(args *core.BuildStepFinishedArgs) {
+	p := newWebhookPayload(webhookBuildStepFinished, args.Options)
+	p.Step = newJSONStep(args.Step)
+	p.Order = args.Order
+	p.Result = webhookResult(args.Successful)
+	p.Message = args.Message
+
+	h.lock.Lock()
+	p.Duration = since(h.startStep[args.Step.SafeID()])
+	delete(h.startStep, args.Step.SafeID())
+	// The first failure is what broke the build
+	if !args.Successful && h.failedStep == "" {
+		h.failedStep = args.Step.DisplayName()
+		h.failedMsg = args.Message
+	}
+	hooks := h.hooks
+	h.lock.Unlock()
+	h.send(hooks, p)
+}
+
+// FullPipelineFinished responds to the FullPipelineFinished event, it waits
+// a while for everything to be delivered since wercker exits right after
+func (h *WebhookHandler) FullPipelineFinished(args *core.FullPipelineFinishedArgs) {
+	p := newWebhookPayload(webhookFullPipelineFinished, args.Options)
+	p.Result = webhookResult(args.MainSuccessful && (!args.RanAfterSteps || args.AfterStepSuccessful))
+
+	h.lock.Lock()
+	p.Duration = since(h.startBuild)
+	p.FailedStepName = h.failedStep
+	p.FailedStepMessage = h.failedMsg
+	hooks := h.hooks
+	h.lock.Unlock()
+	h.send(hooks, p)
+
+	done := make(chan struct{})
+	go func() {
+		h.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(webhookFinalWait):
+		h.logger.Warnln("Gave up waiting for webhooks to be delivered after", webhookFinalWait)
+	}
+}
+
+// send queues p for the hooks that want it, dropping it rather than holding
+// up the build when the queue is full
+func (h *WebhookHandler) send(hooks []*Webhook, p *WebhookPayload) {
+	for _, hook := range hooks {
+		if !hook.wants(p.Event) {
+			continue
+		}
+		h.pending.Add(1)
+		select {
+		case h.queue <- &webhookDelivery{hook: hook, payload: p}:
+		default:
+			h.pending.Done()
+			h.logger.Warnln("Too many webhooks waiting to be delivered, dropping", p.Event, "for", hook.URL)
+		}
+	}
+}
+
+func (h *WebhookHandler) deliverAll() {
+	for d := range h.queue {
+		if err := h.deliver(d.hook, d.payload); err != nil {
+			h.logger.WithField("Error", err).Warnln("Unable to deliver webhook to", d.hook.URL)
+		}
+		h.pending.Done()
+	}
+}
+
+// deliver POSTs p to hook, retrying when the hook is unreachable, has
+// trouble on its end or asks us to slow down, every attempt carries the
+// same delivery ID
+func (h *WebhookHandler) deliver(hook *Webhook, p *WebhookPayload) error {
+	var body []byte
+	var err error
+	if hook.Template != nil {
+		buf := &bytes.Buffer{}
+		err = hook.Template.Execute(buf, p)
+		body = buf.Bytes()
+	} else {
+		body, err = json.Marshal(p)
+	}
+	if err != nil {
+		return err
+	}
+
+	delivery := uuid.NewRandom().String()
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = h.post(hook, delivery, p.Event, body)
+		if err == nil || !retry || attempt >= webhookAttempts {
+			return err
+		}
+		h.logger.Debugln("Retrying webhook", hook.URL, "after:", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes one delivery attempt, whether it's worth another one only
+// matters when there's an error
+func (h *WebhookHandler) post(hook *Webhook, delivery, event string, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", hook.ContentType)
+	req.Header.Set("User-Agent", "wercker/"+util.Version())
+	req.Header.Set("X-Wercker-Event", event)
+	req.Header.Set("X-Wercker-Delivery", delivery)
+	if hook.Secret != "" {
+		req.Header.Set("X-Wercker-Signature", hook.sign(body))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		// Any other client error won't go away by sending it again
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("Webhook responded with %s", resp.Status)
+	}
+	return false, nil
+}
+
+func webhookResult(successful bool) string {
+	if successful {
+		return "passed"
+	}
+	return "failed"
+}
+
+func newWebhookPayload(event string, options *core.PipelineOptions) *WebhookPayload {
+	p := &WebhookPayload{
+		Event:     event,
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	if options != nil {
+		p.BuildID = options.BuildID
+		p.DeployID = options.DeployID
+		p.PipelineName = options.Pipeline
+		p.ApplicationName = options.ApplicationName
+		p.ApplicationOwnerName = options.ApplicationOwnerName
+		if options.GitOptions != nil {
+			p.GitBranch = options.GitBranch
+			p.GitCommit = options.GitCommit
+		}
+	}
+	return p
+}
+
+// WebhookPayload is what we POST to a webhook, or what its template gets,
+// durations are in milliseconds
+type WebhookPayload struct {
+	Event                string `json:"event"`
+	Timestamp            string `json:"timestamp"`
+	BuildID              string `json:"buildId,omitempty"`
+	DeployID             string `json:"deployId,omitempty"`
+	PipelineName         string `json:"pipelineName,omitempty"`
+	ApplicationName      string `json:"applicationName,omitempty"`
+	ApplicationOwnerName string `json:"applicationOwnerName,omitempty"`
+	GitBranch            string `json:"gitBranch,omitempty"`
+	GitCommit            string `json:"gitCommit,omitempty"`
+
+	Step     *JSONStep `json:"step,omitempty"`
+	Order    int       `json:"order,omitempty"`
+	Result   string    `json:"result,omitempty"`
+	Duration int64     `json:"duration,omitempty"`
+	Message  string    `json:"message,omitempty"`
+
+	FailedStepName    string `json:"failedStepName,omitempty"`
+	FailedStepMessage string `json:"failedStepMessage,omitempty"`
+}
@@ -0,0 +1,232 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package event
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/core"
+	"github.com/wercker/wercker/util"
+)
+
+type WebhookSuite struct {
+	*util.TestSuite
+	backoff   time.Duration
+	queueSize int
+	finalWait time.Duration
+}
+
+func TestWebhookSuite(t *testing.T) {
+	suiteTester := &WebhookSuite{TestSuite: &util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+// webhookServer records what it's sent, failing the first failures times
+// with status
+type webhookServer struct {
+	*httptest.Server
+	lock     sync.Mutex
+	failures int
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newWebhookServer(failures int) *webhookServer {
+	s := &webhookServer{failures: failures, status: http.StatusServiceUnavailable}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		if len(s.requests) <= s.failures {
+			w.WriteHeader(s.status)
+		}
+	}))
+	return s
+}
+
+func (s *WebhookSuite) SetupTest() {
+	s.TestSuite.SetupTest()
+	s.backoff, s.queueSize, s.finalWait = webhookBackoff, webhookQueueSize, webhookFinalWait
+	webhookBackoff = time.Millisecond
+}
+
+func (s *WebhookSuite) TearDownTest() {
+	webhookBackoff, webhookQueueSize, webhookFinalWait = s.backoff, s.queueSize, s.finalWait
+	s.TestSuite.TearDownTest()
+}
+
+func (s *WebhookSuite) runBuild(hooks ...*Webhook) {
+	options := core.EmptyPipelineOptions()
+	options.BuildID = "build-1"
+	options.Pipeline = "build"
+	one, err := core.NewStep(&core.StepConfig{ID: "script", Name: "one", Data: map[string]string{"code": "true"}}, options)
+	s.Require().NoError(err)
+	two, err := core.NewStep(&core.StepConfig{ID: "script", Name: "two", Data: map[string]string{"code": "false"}}, options)
+	s.Require().NoError(err)
+
+	e := core.NewNormalizedEmitter()
+	NewWebhookHandler(hooks).ListenTo(e)
+	e.Emit(core.BuildStarted, &core.BuildStartedArgs{Options: options})
+	e.Emit(core.BuildStepStarted, &core.BuildStepStartedArgs{Step: one, Order: 3})
+	e.Emit(core.BuildStepFinished, &core.BuildStepFinishedArgs{Successful: true})
+	e.Emit(core.BuildStepStarted, &core.BuildStepStartedArgs{Step: two, Order: 4})
+	e.Emit(core.BuildStepFinished, &core.BuildStepFinishedArgs{Successful: false, Message: "exit 1"})
+	e.Emit(core.FullPipelineFinished, &core.FullPipelineFinishedArgs{MainSuccessful: false})
+}
+
+func (s *WebhookSuite) TestDeliver() {
+	server := newWebhookServer(0)
+	defer server.Close()
+	hook, err := NewWebhook(server.URL, "sekrit", nil, "", "")
+	s.Require().NoError(err)
+
+	s.runBuild(hook)
+
+	s.Require().Len(server.bodies, 4)
+	events := []string{}
+	for i, body := range server.bodies {
+		mac := hmac.New(sha256.New, []byte("sekrit"))
+		mac.Write(body)
+		s.Equal("sha256="+hex.EncodeToString(mac.Sum(nil)), server.requests[i].Header.Get("X-Wercker-Signature"))
+		events = append(events, server.requests[i].Header.Get("X-Wercker-Event"))
+	}
+	s.Equal([]string{"buildStarted", "buildStepFinished", "buildStepFinished", "fullPipelineFinished"}, events)
+
+	step := &WebhookPayload{}
+	s.Require().NoError(json.Unmarshal(server.bodies[1], step))
+	s.Equal("one", step.Step.DisplayName)
+	s.Equal("passed", step.Result)
+
+	finished := &WebhookPayload{}
+	s.Require().NoError(json.Unmarshal(server.bodies[3], finished))
+	s.Equal("build-1", finished.BuildID)
+	s.Equal("failed", finished.Result)
+	s.Equal("two", finished.FailedStepName)
+	s.Equal("exit 1", finished.FailedStepMessage)
+}
+
+func (s *WebhookSuite) TestEventsAndTemplate() {
+	server := newWebhookServer(0)
+	defer server.Close()
+	hook, err := NewWebhook(server.URL, "", []string{"fullPipelineFinished"}, `{"text": {{json (printf "%s %s at %s" .PipelineName .Result .FailedStepMessage)}}}`, "")
+	s.Require().NoError(err)
+
+	s.runBuild(hook)
+
+	s.Require().Len(server.bodies, 1)
+	s.Equal(`{"text": "build failed at \"exit 1\""}`, string(server.bodies[0]))
+	s.Equal("application/json", server.requests[0].Header.Get("Content-Type"))
+	s.Equal("", server.requests[0].Header.Get("X-Wercker-Signature"))
+
+	server = newWebhookServer(0)
+	defer server.Close()
+	hook, err = NewWebhook(server.URL, "", []string{"fullPipelineFinished"}, "{{.Result}}", "text/plain")
+	s.Require().NoError(err)
+
+	s.runBuild(hook)
+
+	s.Require().Len(server.bodies, 1)
+	s.Equal("failed", string(server.bodies[0]))
+	s.Equal("text/plain", server.requests[0].Header.Get("Content-Type"))
+}
+
+func (s *WebhookSuite) TestBusyWebhook() {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	webhookQueueSize = 1
+	webhookFinalWait = 10 * time.Millisecond
+	hook, err := NewWebhook(server.URL, "", nil, "", "")
+	s.Require().NoError(err)
+
+	// Neither a full queue nor the final wait holds up the build
+	done := make(chan struct{})
+	go func() {
+		s.runBuild(hook)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		s.Fail("the build waited on a webhook that doesn't answer")
+	}
+}
+
+func (s *WebhookSuite) TestRetry() {
+	server := newWebhookServer(2)
+	defer server.Close()
+	hook, err := NewWebhook(server.URL, "", []string{"buildStarted"}, "", "")
+	s.Require().NoError(err)
+
+	s.runBuild(hook)
+
+	s.Require().Len(server.requests, 3, "two failures, then delivered")
+	delivery := server.requests[0].Header.Get("X-Wercker-Delivery")
+	s.NotEmpty(delivery)
+	s.Equal(delivery, server.requests[2].Header.Get("X-Wercker-Delivery"))
+}
+
+func (s *WebhookSuite) TestNoRetryOnClientError() {
+	server := newWebhookServer(2)
+	server.status = http.StatusBadRequest
+	defer server.Close()
+	hook, err := NewWebhook(server.URL, "", []string{"buildStarted"}, "", "")
+	s.Require().NoError(err)
+
+	s.runBuild(hook)
+
+	s.Len(server.requests, 1, "a bad request isn't sent again")
+}
+
+func (s *WebhookSuite) TestAddWebhooksLate() {
+	server := newWebhookServer(0)
+	defer server.Close()
+	hook, err := NewWebhook(server.URL, "", []string{"buildStarted"}, "", "")
+	s.Require().NoError(err)
+
+	h := NewWebhookHandler(nil)
+	e := core.NewNormalizedEmitter()
+	h.ListenTo(e)
+	e.Emit(core.BuildStarted, &core.BuildStartedArgs{Options: core.EmptyPipelineOptions()})
+	h.AddWebhooks(hook)
+	e.Emit(core.FullPipelineFinished, &core.FullPipelineFinishedArgs{MainSuccessful: true})
+
+	s.Len(server.requests, 1, "hooks added late still hear the build started")
+}
+
+func (s *WebhookSuite) TestNewWebhook() {
+	_, err := NewWebhook("ftp://example.com", "", nil, "", "")
+	s.Error(err)
+	_, err = NewWebhook("https://example.com", "", []string{"buildExploded"}, "", "")
+	s.Error(err)
+	_, err = NewWebhook("https://example.com", "", nil, "{{", "")
+	s.Error(err)
+}
@@ -0,0 +1,36 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package fakeruntime runs pipelines in a sandbox runtime for tests. The
+// tests of the docker package can't import it and use the sandbox directly.
+package fakeruntime
+
+import (
+	"github.com/wercker/wercker/docker"
+	"github.com/wercker/wercker/internal/fakeruntime/sandbox"
+)
+
+// New makes a sandbox runtime rooted at root and the DockerOptions that make
+// the runner use it
+func New(root string) (*sandbox.Runtime, *dockerlocal.DockerOptions, error) {
+	r, err := sandbox.New(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, &dockerlocal.DockerOptions{
+		DockerHost:  "fake://" + r.Root(),
+		DockerLocal: true,
+		Client:      r,
+	}, nil
+}
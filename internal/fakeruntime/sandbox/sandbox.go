@@ -0,0 +1,1035 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+// Package sandbox is a container runtime for tests that runs every
+// container as a local shell process in a sandbox directory. Use
+// fakeruntime.New to run pipelines in it.
+package sandbox
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/pborman/uuid"
+	"github.com/wercker/wercker/util"
+)
+
+var (
+	errFakeInteractive = errors.New("Interactive sessions are not supported by the fake runtime")
+
+	// fakeContentTag matches names tagged with a sha256 hex digest
+	fakeContentTag = regexp.MustCompile(`:[0-9a-f]{64}$`)
+)
+
+// Runtime has the ContainerRuntime methods but doesn't need a daemon: every
+// container is a shell process on the local machine and every path the
+// pipeline touches has to live under the runtime's root directory. It is
+// meant for tests, it is not a security boundary.
+//
+// To use it point GuestRoot, MntRoot and ReportRoot of the PipelineOptions
+// somewhere under Root() and pass it to the runner as DockerOptions.Client.
+type Runtime struct {
+	root       string
+	shell      string
+	containers map[string]*fakeContainer
+	images     map[string]*docker.Image
+	execs      map[string]*fakeExec
+	networks   map[string]*docker.Network
+	lock       sync.Mutex
+	logger     *util.LogEntry
+}
+
+type fakeContainer struct {
+	*docker.Container
+	hostConfig *docker.HostConfig
+	proc       *fakeProcess
+}
+
+// fakeProcess is one run of a container, a restart makes a new one
+type fakeProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *fakeStream
+	stderr *fakeStream
+	done   chan struct{}
+	exit   int
+}
+
+type fakeExec struct {
+	opts     docker.CreateExecOptions
+	running  bool
+	exitCode int
+}
+
+// fakeStream keeps everything a process wrote (for Logs) and forwards it to
+// whoever is currently attached or following the logs
+type fakeStream struct {
+	buf       bytes.Buffer
+	attached  io.Writer
+	followers []io.Writer
+	lock      sync.Mutex
+}
+
+func (s *fakeStream) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.buf.Write(p)
+	if s.attached != nil {
+		s.attached.Write(p)
+	}
+	for _, w := range s.followers {
+		w.Write(p)
+	}
+	return len(p), nil
+}
+
+func (s *fakeStream) attach(w io.Writer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.attached = w
+}
+
+// follow writes what we have so far to w (unless history is false) and then
+// everything that comes after, until the returned func is called
+func (s *fakeStream) follow(w io.Writer, history bool) func() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if history {
+		w.Write(s.buf.Bytes())
+	}
+	s.followers = append(s.followers, w)
+	return func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for i, f := range s.followers {
+			if f == w {
+				s.followers = append(s.followers[:i], s.followers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func (s *fakeStream) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.buf.String()
+}
+
+// New makes a sandbox rooted at root
+func New(root string) (*Runtime, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	shell, err := exec.LookPath("bash")
+	if err != nil {
+		shell = "/bin/sh"
+	}
+
+	r := &Runtime{
+		root:       root,
+		shell:      shell,
+		containers: map[string]*fakeContainer{},
+		images:     map[string]*docker.Image{},
+		execs:      map[string]*fakeExec{},
+		networks:   map[string]*docker.Network{},
+		logger:     util.RootLogger().WithField("Logger", "FakeRuntime"),
+	}
+	return r, nil
+}
+
+// Root is the directory all guest paths have to live under
+func (r *Runtime) Root() string {
+	return r.root
+}
+
+// Close kills anything still running
+func (r *Runtime) Close() {
+	r.lock.Lock()
+	for _, c := range r.containers {
+		r.kill(c)
+	}
+	r.lock.Unlock()
+}
+
+// guestPath makes sure p doesn't escape the sandbox
+func (r *Runtime) guestPath(p string) (string, error) {
+	clean := filepath.Clean(p)
+	if clean != r.root && !strings.HasPrefix(clean, r.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("Path %s is outside of the fake runtime root %s", p, r.root)
+	}
+	return clean, nil
+}
+
+func (r *Runtime) container(id string) (*fakeContainer, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if c, ok := r.containers[id]; ok {
+		return c, nil
+	}
+	for _, c := range r.containers {
+		if c.Name == id {
+			return c, nil
+		}
+	}
+	return nil, &docker.NoSuchContainer{ID: id}
+}
+
+// command works out what to run for a config, anything that looks like a
+// shell is replaced by the local shell since there is no image to take it from
+func (r *Runtime) command(config *docker.Config) []string {
+	args := append([]string{}, config.Entrypoint...)
+	args = append(args, config.Cmd...)
+	if len(args) == 0 {
+		return []string{r.shell}
+	}
+	switch filepath.Base(args[0]) {
+	case "sh", "bash", "ash":
+		args[0] = r.shell
+	}
+	return args
+}
+
+func (r *Runtime) env(config *docker.Config) []string {
+	env := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + r.root,
+	}
+	return append(env, config.Env...)
+}
+
+// bind mimics a docker bind by snapshotting read-only binds into the guest
+// path and symlinking read-write ones
+func (r *Runtime) bind(bind string) error {
+	parts := strings.Split(bind, ":")
+	if len(parts) < 2 {
+		return fmt.Errorf("Invalid bind: %s", bind)
+	}
+	guest, err := r.guestPath(parts[1])
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(guest), 0755); err != nil {
+		return err
+	}
+	os.RemoveAll(guest)
+
+	// Named volumes live in the sandbox and are shared like the real thing
+	if !filepath.IsAbs(parts[0]) {
+		volume := filepath.Join(r.root, "volumes", parts[0])
+		if err := os.MkdirAll(volume, 0755); err != nil {
+			return err
+		}
+		return os.Symlink(volume, guest)
+	}
+
+	if len(parts) > 2 && parts[2] == "rw" {
+		return os.Symlink(parts[0], guest)
+	}
+	return exec.Command("cp", "-RL", parts[0], guest).Run()
+}
+
+// CreateContainer records the config and sets up the binds
+func (r *Runtime) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	r.lock.Lock()
+	for _, c := range r.containers {
+		if opts.Name != "" && c.Name == opts.Name {
+			r.lock.Unlock()
+			return nil, docker.ErrContainerAlreadyExists
+		}
+	}
+	r.lock.Unlock()
+
+	config := opts.Config
+	if config == nil {
+		config = &docker.Config{}
+	}
+	if opts.HostConfig != nil {
+		for _, bind := range opts.HostConfig.Binds {
+			if err := r.bind(bind); err != nil {
+				return nil, err
+			}
+		}
+		for mount := range opts.HostConfig.Tmpfs {
+			guest, err := r.guestPath(mount)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(guest, 0755); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	c := &fakeContainer{
+		Container: &docker.Container{
+			ID:     strings.Replace(uuid.NewRandom().String(), "-", "", -1),
+			Name:   opts.Name,
+			Config: config,
+			Image:  config.Image,
+		},
+		hostConfig: opts.HostConfig,
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.containers[c.ID] = c
+	return c.Container, nil
+}
+
+// StartContainer spawns the container's process
+func (r *Runtime) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	c, err := r.container(id)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if c.proc != nil && !c.proc.exited() {
+		return &docker.ContainerAlreadyRunning{ID: id}
+	}
+	return r.start(c)
+}
+
+func (r *Runtime) start(c *fakeContainer) error {
+	args := r.command(c.Config)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = r.root
+	cmd.Env = r.env(c.Config)
+
+	p := &fakeProcess{
+		cmd:    cmd,
+		stdout: &fakeStream{},
+		stderr: &fakeStream{},
+		done:   make(chan struct{}),
+	}
+	cmd.Stdout = p.stdout
+	cmd.Stderr = p.stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	p.stdin = stdin
+
+	r.logger.Debugln("Starting", c.ID, args)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	c.proc = p
+	go func() {
+		p.exit = exitCode(cmd.Wait())
+		close(p.done)
+	}()
+	return nil
+}
+
+func (p *fakeProcess) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+// kill expects r.lock to be held
+func (r *Runtime) kill(c *fakeContainer) bool {
+	if c.proc == nil || c.proc.exited() {
+		return false
+	}
+	c.proc.cmd.Process.Kill()
+	<-c.proc.done
+	return true
+}
+
+// StopContainer kills the container's process
+func (r *Runtime) StopContainer(id string, timeout uint) error {
+	c, err := r.container(id)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.kill(c) {
+		return &docker.ContainerNotRunning{ID: id}
+	}
+	return nil
+}
+
+// RestartContainer kills the process and starts a fresh one
+func (r *Runtime) RestartContainer(id string, timeout uint) error {
+	c, err := r.container(id)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.kill(c)
+	return r.start(c)
+}
+
+// RemoveContainer forgets about the container
+func (r *Runtime) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	c, err := r.container(opts.ID)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if c.proc != nil && !c.proc.exited() && !opts.Force {
+		return fmt.Errorf("Container %s is running, stop it or use force", opts.ID)
+	}
+	r.kill(c)
+	delete(r.containers, c.ID)
+	return nil
+}
+
+// WaitContainer blocks until the current process exits
+func (r *Runtime) WaitContainer(id string) (int, error) {
+	c, err := r.container(id)
+	if err != nil {
+		return -1, err
+	}
+	r.lock.Lock()
+	p := c.proc
+	r.lock.Unlock()
+	if p == nil {
+		return -1, &docker.ContainerNotRunning{ID: id}
+	}
+	<-p.done
+	return p.exit, nil
+}
+
+// InspectContainer reports the container and the state of its process
+func (r *Runtime) InspectContainer(id string) (*docker.Container, error) {
+	c, err := r.container(id)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	container := *c.Container
+	container.HostConfig = c.hostConfig
+	// Everything runs on the local machine, so that's where every network
+	// endpoint ends up
+	if c.hostConfig != nil && c.hostConfig.NetworkMode != "" {
+		container.NetworkSettings = &docker.NetworkSettings{
+			Networks: map[string]docker.ContainerNetwork{
+				c.hostConfig.NetworkMode: {IPAddress: "127.0.0.1"},
+			},
+		}
+	}
+	if c.proc != nil {
+		container.State.Running = !c.proc.exited()
+		if !container.State.Running {
+			container.State.ExitCode = c.proc.exit
+		}
+	}
+	return &container, nil
+}
+
+// ListContainers supports the All flag and label filters
+func (r *Runtime) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	containers := []docker.APIContainers{}
+	for _, c := range r.containers {
+		running := c.proc != nil && !c.proc.exited()
+		if !running && !opts.All {
+			continue
+		}
+		if !fakeLabelsMatch(c.Config.Labels, opts.Filters["label"]) {
+			continue
+		}
+		status := "Created"
+		if running {
+			status = "Up"
+		} else if c.proc != nil {
+			status = fmt.Sprintf("Exited (%d)", c.proc.exit)
+		}
+		containers = append(containers, docker.APIContainers{
+			ID:     c.ID,
+			Image:  c.Image,
+			Names:  []string{"/" + c.Name},
+			Labels: c.Config.Labels,
+			Status: status,
+		})
+	}
+	return containers, nil
+}
+
+// fakeLabelsMatch checks "key" and "key=value" label filters
+func fakeLabelsMatch(labels map[string]string, filters []string) bool {
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, "=", 2)
+		value, ok := labels[parts[0]]
+		if !ok || (len(parts) == 2 && value != parts[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// AttachToContainer hooks the streams up to the running process and blocks
+// until it exits, like the real thing
+func (r *Runtime) AttachToContainer(opts docker.AttachToContainerOptions) error {
+	c, err := r.container(opts.Container)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	p := c.proc
+	r.lock.Unlock()
+	if p == nil || p.exited() {
+		return &docker.ContainerNotRunning{ID: opts.Container}
+	}
+
+	if opts.Stdout && opts.OutputStream != nil {
+		p.stdout.attach(opts.OutputStream)
+	}
+	if opts.Stderr && opts.ErrorStream != nil {
+		p.stderr.attach(opts.ErrorStream)
+	}
+	if opts.Stdin && opts.InputStream != nil {
+		go func() {
+			io.Copy(p.stdin, opts.InputStream)
+		}()
+	}
+
+	if opts.Success != nil {
+		opts.Success <- struct{}{}
+		<-opts.Success
+	}
+
+	<-p.done
+	return nil
+}
+
+// Logs writes out everything the current process printed, with Follow it
+// keeps going until the process exits
+func (r *Runtime) Logs(opts docker.LogsOptions) error {
+	c, err := r.container(opts.Container)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	p := c.proc
+	r.lock.Unlock()
+	if p == nil {
+		return nil
+	}
+
+	if opts.Follow {
+		history := opts.Tail != "0"
+		if opts.Stdout && opts.OutputStream != nil {
+			defer p.stdout.follow(opts.OutputStream, history)()
+		}
+		if opts.Stderr && opts.ErrorStream != nil {
+			defer p.stderr.follow(opts.ErrorStream, history)()
+		}
+		<-p.done
+		return nil
+	}
+
+	if opts.Stdout && opts.OutputStream != nil {
+		io.WriteString(opts.OutputStream, p.stdout.String())
+	}
+	if opts.Stderr && opts.ErrorStream != nil {
+		io.WriteString(opts.ErrorStream, p.stderr.String())
+	}
+	return nil
+}
+
+// CopyFromContainer tars up a guest path the same way docker does, rooted at
+// the base name of the resource
+func (r *Runtime) CopyFromContainer(opts docker.CopyFromContainerOptions) error {
+	if _, err := r.container(opts.Container); err != nil {
+		return err
+	}
+	resource, err := r.guestPath(opts.Resource)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(resource); err != nil {
+		return &docker.Error{
+			Status:  500,
+			Message: fmt.Sprintf("Could not find the file %s in container %s", opts.Resource, opts.Container),
+		}
+	}
+
+	tw := tar.NewWriter(opts.OutputStream)
+	base := filepath.Dir(resource)
+	err = filepath.Walk(resource, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// UploadDir is where files uploaded to a container end up, containers share
+// the host filesystem so uploads get a directory of their own
+func (r *Runtime) UploadDir(id string) string {
+	return filepath.Join(r.root, "uploads", id)
+}
+
+// UploadToContainer unpacks a tarball into the container's UploadDir
+func (r *Runtime) UploadToContainer(id string, opts docker.UploadToContainerOptions) error {
+	c, err := r.container(id)
+	if err != nil {
+		return err
+	}
+	root := filepath.Join(r.UploadDir(c.ID), filepath.FromSlash(opts.Path))
+	tr := tar.NewReader(opts.InputStream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(root, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(name, r.UploadDir(c.ID)) {
+			return fmt.Errorf("Refusing to unpack %s outside of the container", hdr.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(name, os.FileMode(hdr.Mode))
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, name)
+		default:
+			var f *os.File
+			f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode))
+			if err == nil {
+				_, err = io.Copy(f, tr)
+				f.Close()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// CommitContainer remembers an image, there is no filesystem to snapshot
+func (r *Runtime) CommitContainer(opts docker.CommitContainerOptions) (*docker.Image, error) {
+	c, err := r.container(opts.Container)
+	if err != nil {
+		return nil, err
+	}
+	parent, err := r.InspectImage(c.Image)
+	if err != nil {
+		return nil, err
+	}
+	config := opts.Run
+	if config == nil {
+		config = c.Config
+	}
+	image := &docker.Image{
+		ID:        strings.Replace(uuid.NewRandom().String(), "-", "", -1),
+		Container: c.ID,
+		Parent:    parent.ID,
+		Created:   time.Now(),
+		Config:    config,
+		Author:    opts.Author,
+		Comment:   opts.Message,
+	}
+	tag := opts.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.images[image.ID] = image
+	if opts.Repository != "" {
+		r.images[fmt.Sprintf("%s:%s", opts.Repository, tag)] = image
+	}
+	return image, nil
+}
+
+// CreateExec records what to run
+func (r *Runtime) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error) {
+	if _, err := r.container(opts.Container); err != nil {
+		return nil, err
+	}
+	id := uuid.NewRandom().String()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.execs[id] = &fakeExec{opts: opts}
+	return &docker.Exec{ID: id}, nil
+}
+
+// StartExec runs the command next to the container's process, like docker
+// a non-zero exit is not an error, use InspectExec to get the exit code
+func (r *Runtime) StartExec(id string, opts docker.StartExecOptions) error {
+	r.lock.Lock()
+	fe, ok := r.execs[id]
+	r.lock.Unlock()
+	if !ok {
+		return &docker.NoSuchExec{ID: id}
+	}
+	c, err := r.container(fe.opts.Container)
+	if err != nil {
+		return err
+	}
+
+	args := fe.opts.Cmd
+	if len(args) == 0 {
+		args = []string{r.shell}
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = r.root
+	cmd.Env = append(r.env(c.Config), fe.opts.Env...)
+	cmd.Stdin = opts.InputStream
+	cmd.Stdout = opts.OutputStream
+	cmd.Stderr = opts.ErrorStream
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	fe.running = true
+	r.lock.Unlock()
+	wait := func() {
+		exit := exitCode(cmd.Wait())
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		fe.running = false
+		fe.exitCode = exit
+	}
+	if opts.Detach {
+		go wait()
+		return nil
+	}
+	wait()
+	return nil
+}
+
+// InspectExec reports whether an exec is still running and how it exited
+func (r *Runtime) InspectExec(id string) (*docker.ExecInspect, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	fe, ok := r.execs[id]
+	if !ok {
+		return nil, &docker.NoSuchExec{ID: id}
+	}
+	return &docker.ExecInspect{
+		ID:          id,
+		Running:     fe.running,
+		ExitCode:    fe.exitCode,
+		ContainerID: fe.opts.Container,
+	}, nil
+}
+
+// InspectImage returns a committed image or pretends any other image exists.
+// Tags that are content hashes only ever come from a local build, so those
+// have to have been built or tagged here.
+func (r *Runtime) InspectImage(name string) (*docker.Image, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if image, ok := r.images[name]; ok {
+		return image, nil
+	}
+	if !strings.Contains(name, ":") {
+		if image, ok := r.images[name+":latest"]; ok {
+			return image, nil
+		}
+	}
+	if fakeContentTag.MatchString(name) {
+		return nil, docker.ErrNoSuchImage
+	}
+	return &docker.Image{ID: name, Config: &docker.Config{}}, nil
+}
+
+// ImageHistory has one entry per committed image down to an image that
+// was never committed here
+func (r *Runtime) ImageHistory(name string) ([]docker.ImageHistory, error) {
+	history := []docker.ImageHistory{}
+	for name != "" {
+		image, err := r.InspectImage(name)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, docker.ImageHistory{ID: image.ID, Size: image.Size})
+		name = image.Parent
+	}
+	return history, nil
+}
+
+// PullImage NOP
+func (r *Runtime) PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error {
+	return nil
+}
+
+// PushImage NOP
+func (r *Runtime) PushImage(opts docker.PushImageOptions, auth docker.AuthConfiguration) error {
+	return nil
+}
+
+// TagImage adds another name for an image
+func (r *Runtime) TagImage(name string, opts docker.TagImageOptions) error {
+	image, err := r.InspectImage(name)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.images[fmt.Sprintf("%s:%s", opts.Repo, opts.Tag)] = image
+	return nil
+}
+
+// RemoveImage untags an image that has other tags, otherwise it forgets
+// about the image and all its names
+func (r *Runtime) RemoveImage(name string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	image, ok := r.images[name]
+	if !ok {
+		return docker.ErrNoSuchImage
+	}
+	if strings.Contains(name, ":") {
+		for k, v := range r.images {
+			if v == image && k != name && strings.Contains(k, ":") {
+				delete(r.images, name)
+				return nil
+			}
+		}
+	}
+	for k, v := range r.images {
+		if v == image {
+			delete(r.images, k)
+		}
+	}
+	return nil
+}
+
+// ListImages lists the committed images, only filtering on labels
+func (r *Runtime) ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	listed := map[*docker.Image]int{}
+	images := []docker.APIImages{}
+	for name, image := range r.images {
+		if !strings.Contains(name, ":") {
+			continue
+		}
+		if i, ok := listed[image]; ok {
+			images[i].RepoTags = append(images[i].RepoTags, name)
+			continue
+		}
+		labels := map[string]string{}
+		if image.Config != nil && image.Config.Labels != nil {
+			labels = image.Config.Labels
+		}
+		if !fakeLabelsMatch(labels, opts.Filters["label"]) {
+			continue
+		}
+		images = append(images, docker.APIImages{
+			ID:       image.ID,
+			RepoTags: []string{name},
+			Created:  image.Created.Unix(),
+			Labels:   labels,
+		})
+		listed[image] = len(images) - 1
+	}
+	return images, nil
+}
+
+// ExportImage writes an empty tarball
+func (r *Runtime) ExportImage(opts docker.ExportImageOptions) error {
+	return tar.NewWriter(opts.OutputStream).Close()
+}
+
+// ExportImages writes an empty tarball
+func (r *Runtime) ExportImages(opts docker.ExportImagesOptions) error {
+	return tar.NewWriter(opts.OutputStream).Close()
+}
+
+// LoadImage NOP
+func (r *Runtime) LoadImage(opts docker.LoadImageOptions) error {
+	return nil
+}
+
+// BuildImage checks the Dockerfile is there and records an image, nothing
+// in the Dockerfile is run
+func (r *Runtime) BuildImage(opts docker.BuildImageOptions) error {
+	dockerfile := opts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	if _, err := os.Stat(filepath.Join(opts.ContextDir, dockerfile)); err != nil {
+		return err
+	}
+	image := &docker.Image{
+		ID:     strings.Replace(uuid.NewRandom().String(), "-", "", -1),
+		Config: &docker.Config{},
+	}
+	r.lock.Lock()
+	r.images[image.ID] = image
+	if opts.Name != "" {
+		r.images[opts.Name] = image
+	}
+	r.lock.Unlock()
+	if opts.OutputStream != nil {
+		fmt.Fprintf(opts.OutputStream, "Successfully built %s\n", image.ID)
+	}
+	return nil
+}
+
+// CreateNetwork records a network, there is nothing to isolate
+func (r *Runtime) CreateNetwork(opts docker.CreateNetworkOptions) (*docker.Network, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.networks[opts.Name]; ok {
+		return nil, docker.ErrNetworkAlreadyExists
+	}
+	network := &docker.Network{
+		ID:     strings.Replace(uuid.NewRandom().String(), "-", "", -1),
+		Name:   opts.Name,
+		Driver: opts.Driver,
+	}
+	r.networks[opts.Name] = network
+	return network, nil
+}
+
+// NetworkInfo looks up a network by name or ID
+func (r *Runtime) NetworkInfo(id string) (*docker.Network, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for name, network := range r.networks {
+		if name == id || network.ID == id {
+			return network, nil
+		}
+	}
+	return nil, &docker.NoSuchNetwork{ID: id}
+}
+
+// RemoveNetwork forgets about a network by name or ID
+func (r *Runtime) RemoveNetwork(id string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for name, network := range r.networks {
+		if name == id || network.ID == id {
+			delete(r.networks, name)
+			return nil
+		}
+	}
+	return &docker.NoSuchNetwork{ID: id}
+}
+
+// Ping always works
+func (r *Runtime) Ping() error {
+	return nil
+}
+
+// Version of the fake
+func (r *Runtime) Version() (*docker.Env, error) {
+	return &docker.Env{"Version=fake"}, nil
+}
+
+// RunAndAttach is not supported
+func (r *Runtime) RunAndAttach(name string) error {
+	return errFakeInteractive
+}
+
+// AttachInteractive is not supported
+func (r *Runtime) AttachInteractive(containerID string, cmd []string, env []string, initialStdin []string) error {
+	return errFakeInteractive
+}
+
+// ExecOne runs a command and waits for it
+func (r *Runtime) ExecOne(containerID string, cmd []string, output io.Writer) error {
+	exec, err := r.CreateExec(docker.CreateExecOptions{
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+		Container:    containerID,
+	})
+	if err != nil {
+		return err
+	}
+	return r.StartExec(exec.ID, docker.StartExecOptions{OutputStream: output})
+}
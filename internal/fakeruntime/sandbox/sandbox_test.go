@@ -0,0 +1,169 @@
+//   Copyright 2016 Wercker Holding BV
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package sandbox
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/suite"
+	"github.com/wercker/wercker/util"
+)
+
+type SandboxSuite struct {
+	*util.TestSuite
+	runtime *Runtime
+}
+
+func TestSandboxSuite(t *testing.T) {
+	suiteTester := &SandboxSuite{TestSuite: &util.TestSuite{}}
+	suite.Run(t, suiteTester)
+}
+
+func (s *SandboxSuite) SetupTest() {
+	s.TestSuite.SetupTest()
+	r, err := New(s.WorkingDir())
+	s.Require().NoError(err)
+	s.runtime = r
+}
+
+func (s *SandboxSuite) TearDownTest() {
+	s.runtime.Close()
+	s.TestSuite.TearDownTest()
+}
+
+// start runs a container from image that stays up until the test ends
+func (s *SandboxSuite) start(image string) *docker.Container {
+	container, err := s.runtime.CreateContainer(docker.CreateContainerOptions{
+		Config: &docker.Config{Image: image, Cmd: []string{"sleep", "60"}, Env: []string{"GREETING=hi"}},
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(s.runtime.StartContainer(container.ID, nil))
+	return container
+}
+
+func (s *SandboxSuite) TestExec() {
+	container := s.start("ubuntu")
+
+	exec, err := s.runtime.CreateExec(docker.CreateExecOptions{
+		Container: container.ID,
+		Cmd:       []string{"sh", "-c", `echo "$GREETING $PWD"; echo oops >&2; exit 3`},
+	})
+	s.Require().NoError(err)
+	var stdout, stderr bytes.Buffer
+	s.Require().NoError(s.runtime.StartExec(exec.ID, docker.StartExecOptions{OutputStream: &stdout, ErrorStream: &stderr}))
+	s.Equal("hi "+s.runtime.Root()+"\n", stdout.String(), "execs get the container env and run in the root")
+	s.Equal("oops\n", stderr.String())
+
+	inspect, err := s.runtime.InspectExec(exec.ID)
+	s.Require().NoError(err)
+	s.False(inspect.Running)
+	s.Equal(3, inspect.ExitCode)
+	s.Equal(container.ID, inspect.ContainerID)
+
+	_, err = s.runtime.InspectExec("missing")
+	s.Error(err)
+	_, err = s.runtime.CreateExec(docker.CreateExecOptions{Container: "missing"})
+	s.Error(err)
+}
+
+func (s *SandboxSuite) TestExecDetach() {
+	container := s.start("ubuntu")
+
+	exec, err := s.runtime.CreateExec(docker.CreateExecOptions{
+		Container: container.ID,
+		Cmd:       []string{"sh", "-c", "sleep 0.2; exit 2"},
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(s.runtime.StartExec(exec.ID, docker.StartExecOptions{Detach: true}))
+	inspect, err := s.runtime.InspectExec(exec.ID)
+	s.Require().NoError(err)
+	s.True(inspect.Running)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for inspect.Running && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		inspect, err = s.runtime.InspectExec(exec.ID)
+		s.Require().NoError(err)
+	}
+	s.False(inspect.Running)
+	s.Equal(2, inspect.ExitCode)
+}
+
+func (s *SandboxSuite) TestExecOne() {
+	container := s.start("ubuntu")
+
+	var out bytes.Buffer
+	s.Require().NoError(s.runtime.ExecOne(container.ID, []string{"sh", "-c", "echo one"}, &out))
+	s.Equal("one\n", out.String())
+}
+
+func (s *SandboxSuite) TestCommit() {
+	container := s.start("ubuntu")
+
+	image, err := s.runtime.CommitContainer(docker.CommitContainerOptions{
+		Container:  container.ID,
+		Repository: "build",
+		Tag:        "one",
+		Message:    "first",
+	})
+	s.Require().NoError(err)
+	s.Equal("ubuntu", image.Parent)
+	s.Equal(container.ID, image.Container)
+	s.Equal("first", image.Comment)
+
+	tagged, err := s.runtime.InspectImage("build:one")
+	s.Require().NoError(err)
+	s.Equal(image.ID, tagged.ID)
+	byID, err := s.runtime.InspectImage(image.ID)
+	s.Require().NoError(err)
+	s.Equal(image, byID)
+
+	// Without a tag the commit is latest
+	latest, err := s.runtime.CommitContainer(docker.CommitContainerOptions{Container: container.ID, Repository: "build"})
+	s.Require().NoError(err)
+	found, err := s.runtime.InspectImage("build")
+	s.Require().NoError(err)
+	s.Equal(latest.ID, found.ID)
+
+	_, err = s.runtime.CommitContainer(docker.CommitContainerOptions{Container: "missing"})
+	s.Error(err)
+}
+
+func (s *SandboxSuite) TestHistory() {
+	base := s.start("ubuntu")
+	first, err := s.runtime.CommitContainer(docker.CommitContainerOptions{Container: base.ID, Repository: "build", Tag: "latest"})
+	s.Require().NoError(err)
+
+	// Committing on top of a tag we then reuse still remembers the parent
+	next := s.start("build:latest")
+	second, err := s.runtime.CommitContainer(docker.CommitContainerOptions{Container: next.ID, Repository: "build", Tag: "latest"})
+	s.Require().NoError(err)
+	s.Equal(first.ID, second.Parent)
+
+	history, err := s.runtime.ImageHistory("build:latest")
+	s.Require().NoError(err)
+	ids := []string{}
+	for _, layer := range history {
+		ids = append(ids, layer.ID)
+	}
+	s.Equal([]string{second.ID, first.ID, "ubuntu"}, ids)
+
+	history, err = s.runtime.ImageHistory("ubuntu")
+	s.Require().NoError(err)
+	s.Len(history, 1)
+}
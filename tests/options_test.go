@@ -184,6 +184,22 @@ func (s *OptionsSuite) TestBuildOptions() {
 	run(s, globalFlags, pipelineFlags, test, args)
 }
 
+func (s *OptionsSuite) TestAllowPrivileged() {
+	test := func(c *cli.Context) {
+		opts, err := core.NewBuildOptions(util.NewCLISettings(c), emptyEnv())
+		s.Nil(err)
+		s.False(opts.AllowPrivileged)
+	}
+	run(s, globalFlags, pipelineFlags, test, defaultArgs())
+
+	test = func(c *cli.Context) {
+		opts, err := core.NewBuildOptions(util.NewCLISettings(c), emptyEnv())
+		s.Nil(err)
+		s.True(opts.AllowPrivileged)
+	}
+	run(s, globalFlags, pipelineFlags, test, defaultArgs("--allow-privileged"))
+}
+
 func (s *OptionsSuite) TestEmptyDeployOptions() {
 	args := defaultArgs()
 	test := func(c *cli.Context) {
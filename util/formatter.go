@@ -47,6 +47,16 @@ func (f *Formatter) Fail(messages ...string) string {
 	return FormatMessage(failColor, f.ShowColors, messages...)
 }
 
+// Stderr uses failColor (red) for output that went to stderr, a trailing
+// newline is kept outside of the color.
+func (f *Formatter) Stderr(output string) string {
+	if !f.ShowColors || output == "" {
+		return output
+	}
+	trimmed := strings.TrimRight(output, "\n")
+	return fmt.Sprintf("%s%s%s%s", failColor, trimmed, reset, output[len(trimmed):])
+}
+
 // FormatMessage handles one or two messages. If more messages are used, those
 // are ignore. If no messages are used, than it will return an empty string.
 // 1 message : --> message[0]
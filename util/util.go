@@ -17,9 +17,12 @@ package util
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
@@ -210,6 +213,53 @@ func TarPath(writer io.Writer, root string) error {
 	return nil
 }
 
+// HashDir returns a sha256 over the names, modes and contents of everything
+// under root, skipping directories with one of the skip names
+func HashDir(root string, skip []string) (string, error) {
+	h := sha256.New()
+	walkFn := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != root && ContainsString(skip, info.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%o\x00", filepath.ToSlash(rel), info.Mode())
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			io.WriteString(h, target)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fmt.Fprintf(h, "%d\x00", info.Size())
+		_, err = io.Copy(h, f)
+		return err
+	}
+
+	if err := filepath.Walk(root, walkFn); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // Finisher is a helper class for running something either right away or
 // at `defer` time.
 type Finisher struct {
@@ -326,6 +376,47 @@ func SplitSpaceOrComma(str string) []string {
 	})
 }
 
+// tailMaxBytes caps how much of the end of a file TailFile reads
+const tailMaxBytes = 64 * 1024
+
+// TailFile returns the last n lines of the file at path
+func TailFile(path string, n int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := info.Size() - tailMaxBytes
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, os.SEEK_SET); err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	// We probably started in the middle of a line
+	if offset > 0 && len(lines) > 0 {
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, ""), nil
+}
+
 // CounterReader is a io.Reader which wraps a other io.Reader and stores the
 // bytes reader from it.
 type CounterReader struct {
@@ -15,6 +15,9 @@
 package util
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -109,3 +112,50 @@ func (s *UtilSuite) TestSplitFunc() {
 		s.Equal(len(test.output), 2)
 	}
 }
+
+func (s *UtilSuite) TestTailFile() {
+	path := filepath.Join(s.WorkingDir(), "tail.log")
+	err := ioutil.WriteFile(path, []byte("one\ntwo\nthree\n"), 0644)
+	s.Require().Nil(err)
+
+	tail, err := TailFile(path, 2)
+	s.Nil(err)
+	s.Equal("two\nthree\n", tail)
+
+	tail, err = TailFile(path, 10)
+	s.Nil(err)
+	s.Equal("one\ntwo\nthree\n", tail)
+
+	_, err = TailFile(filepath.Join(s.WorkingDir(), "missing.log"), 2)
+	s.Error(err)
+}
+
+func (s *UtilSuite) TestHashDir() {
+	root := filepath.Join(s.WorkingDir(), "project")
+	s.Require().Nil(os.MkdirAll(filepath.Join(root, "_builds"), 0755))
+	s.Require().Nil(ioutil.WriteFile(filepath.Join(root, "wercker.yml"), []byte("box: alpine\n"), 0644))
+
+	first, err := HashDir(root, []string{"_builds"})
+	s.Require().Nil(err)
+
+	// Skipped directories don't count
+	s.Require().Nil(ioutil.WriteFile(filepath.Join(root, "_builds", "out"), []byte("x"), 0644))
+	second, err := HashDir(root, []string{"_builds"})
+	s.Nil(err)
+	s.Equal(first, second)
+
+	s.Require().Nil(ioutil.WriteFile(filepath.Join(root, "wercker.yml"), []byte("box: busybox\n"), 0644))
+	third, err := HashDir(root, []string{"_builds"})
+	s.Nil(err)
+	s.NotEqual(first, third)
+}
+
+func (s *UtilSuite) TestFormatterStderr() {
+	f := &Formatter{ShowColors: true}
+	s.Equal("\x1b[31moops\x1b[m\n", f.Stderr("oops\n"))
+	s.Equal("\x1b[31mno newline\x1b[m", f.Stderr("no newline"))
+	s.Equal("", f.Stderr(""))
+
+	f = &Formatter{ShowColors: false}
+	s.Equal("oops\n", f.Stderr("oops\n"))
+}